@@ -3,12 +3,14 @@ package ast
 import (
 	"bytes"
 	"pearl/token"
+	"strconv"
 	"strings"
 )
 
 type Node interface {
 	TokenLiteral() string
 	String() string
+	Position() (line, col int)
 }
 
 type Statement interface {
@@ -33,6 +35,10 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+// Position of a whole program is unknown, errors get placed by the
+// statement that raised them
+func (p *Program) Position() (int, int) { return 0, 0 }
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -43,17 +49,23 @@ func (p *Program) String() string {
 
 // LetStatement: let x = expr
 type LetStatement struct {
-	Token token.Token
-	Name  *Identifier
-	Value Expression
+	Token   token.Token
+	Name    *Identifier
+	Pattern Expression // set instead of Name for let [a, b] = ... and let {a, b} = ...
+	Value   Expression
 }
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Position() (int, int) { return ls.Token.Line, ls.Token.Col }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("let ")
-	out.WriteString(ls.Name.String())
+	if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	} else {
+		out.WriteString(ls.Name.String())
+	}
 	out.WriteString(" = ")
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
@@ -61,6 +73,22 @@ func (ls *LetStatement) String() string {
 	return out.String()
 }
 
+// YieldStatement hands a value to whoever is iterating over a generator
+type YieldStatement struct {
+	Token token.Token
+	Value Expression // nil for a bare yield, which yields null
+}
+
+func (ys *YieldStatement) statementNode()       {}
+func (ys *YieldStatement) TokenLiteral() string { return ys.Token.Literal }
+func (ys *YieldStatement) Position() (int, int) { return ys.Token.Line, ys.Token.Col }
+func (ys *YieldStatement) String() string {
+	if ys.Value == nil {
+		return "yield"
+	}
+	return "yield " + ys.Value.String()
+}
+
 // ReturnStatement: return expr
 type ReturnStatement struct {
 	Token       token.Token
@@ -69,6 +97,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Position() (int, int) { return rs.Token.Line, rs.Token.Col }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString("return ")
@@ -86,6 +115,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Position() (int, int) { return es.Token.Line, es.Token.Col }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -101,6 +131,7 @@ type BlockStatement struct {
 
 func (bs *BlockStatement) statementNode()       {}
 func (bs *BlockStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BlockStatement) Position() (int, int) { return bs.Token.Line, bs.Token.Col }
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -112,16 +143,25 @@ func (bs *BlockStatement) String() string {
 // ForStatement: for x in iterable { }
 type ForStatement struct {
 	Token    token.Token
-	Variable *Identifier
+	Label    string      // optional, for labelled break/continue
+	Index    *Identifier // optional: the i in for i, x in arr
+	Variable Expression  // an Identifier or a pattern
 	Iterable Expression
 	Body     *BlockStatement
 }
 
 func (fs *ForStatement) statementNode()       {}
 func (fs *ForStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForStatement) Position() (int, int) { return fs.Token.Line, fs.Token.Col }
 func (fs *ForStatement) String() string {
 	var out bytes.Buffer
+	if fs.Label != "" {
+		out.WriteString(fs.Label + ": ")
+	}
 	out.WriteString("for ")
+	if fs.Index != nil {
+		out.WriteString(fs.Index.String() + ", ")
+	}
 	out.WriteString(fs.Variable.String())
 	out.WriteString(" in ")
 	out.WriteString(fs.Iterable.String())
@@ -133,14 +173,19 @@ func (fs *ForStatement) String() string {
 // WhileStatement: while condition { }
 type WhileStatement struct {
 	Token     token.Token
+	Label     string // optional, for labelled break/continue
 	Condition Expression
 	Body      *BlockStatement
 }
 
 func (ws *WhileStatement) statementNode()       {}
 func (ws *WhileStatement) TokenLiteral() string { return ws.Token.Literal }
+func (ws *WhileStatement) Position() (int, int) { return ws.Token.Line, ws.Token.Col }
 func (ws *WhileStatement) String() string {
 	var out bytes.Buffer
+	if ws.Label != "" {
+		out.WriteString(ws.Label + ": ")
+	}
 	out.WriteString("while ")
 	out.WriteString(ws.Condition.String())
 	out.WriteString(" ")
@@ -148,6 +193,126 @@ func (ws *WhileStatement) String() string {
 	return out.String()
 }
 
+// BreakStatement: break [label]
+type BreakStatement struct {
+	Token token.Token
+	Label *Identifier // optional
+}
+
+func (bs *BreakStatement) statementNode()       {}
+func (bs *BreakStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BreakStatement) Position() (int, int) { return bs.Token.Line, bs.Token.Col }
+func (bs *BreakStatement) String() string {
+	if bs.Label != nil {
+		return "break " + bs.Label.String()
+	}
+	return "break"
+}
+
+// ContinueStatement: continue [label]
+type ContinueStatement struct {
+	Token token.Token
+	Label *Identifier // optional
+}
+
+func (cs *ContinueStatement) statementNode()       {}
+func (cs *ContinueStatement) TokenLiteral() string { return cs.Token.Literal }
+func (cs *ContinueStatement) Position() (int, int) { return cs.Token.Line, cs.Token.Col }
+func (cs *ContinueStatement) String() string {
+	if cs.Label != nil {
+		return "continue " + cs.Label.String()
+	}
+	return "continue"
+}
+
+// TryStatement: try { } catch err { }
+type TryStatement struct {
+	Token    token.Token
+	Body     *BlockStatement
+	CatchVar *Identifier // optional
+	Catch    *BlockStatement
+}
+
+func (ts *TryStatement) statementNode()       {}
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TryStatement) Position() (int, int) { return ts.Token.Line, ts.Token.Col }
+func (ts *TryStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(ts.Body.String())
+	out.WriteString(" catch ")
+	if ts.CatchVar != nil {
+		out.WriteString(ts.CatchVar.String() + " ")
+	}
+	out.WriteString(ts.Catch.String())
+	return out.String()
+}
+
+// ImportStatement: import "lib/x.pearl" as x, or
+// import { a, b as c } from "lib/x.pearl"
+type ImportStatement struct {
+	Token token.Token
+	Path  string
+	Alias *Identifier   // optional, defaults to the file name
+	Names []*ImportName // set for selective imports
+}
+
+// ImportName is one binding pulled in by a selective import
+type ImportName struct {
+	Name  *Identifier
+	Alias *Identifier // optional
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) Position() (int, int) { return is.Token.Line, is.Token.Col }
+func (is *ImportStatement) String() string {
+	var out bytes.Buffer
+	out.WriteString("import ")
+	if is.Names != nil {
+		names := []string{}
+		for _, n := range is.Names {
+			if n.Alias != nil {
+				names = append(names, n.Name.String()+" as "+n.Alias.String())
+			} else {
+				names = append(names, n.Name.String())
+			}
+		}
+		out.WriteString("{ " + strings.Join(names, ", ") + " } from ")
+	}
+	out.WriteString(strconv.Quote(is.Path))
+	if is.Alias != nil {
+		out.WriteString(" as " + is.Alias.String())
+	}
+	return out.String()
+}
+
+// StructStatement: struct Point { x, y = 0 }
+type StructStatement struct {
+	Token  token.Token
+	Name   *Identifier
+	Fields []*FunctionParam // Default is used when the constructor leaves a field out
+
+	// Init is fn(x, y = 0) { [x, y] }, built by the parser so constructor
+	// calls bind their arguments exactly like function calls
+	Init *FunctionLiteral
+}
+
+func (ss *StructStatement) statementNode()       {}
+func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *StructStatement) Position() (int, int) { return ss.Token.Line, ss.Token.Col }
+func (ss *StructStatement) String() string {
+	fields := []string{}
+	for _, f := range ss.Fields {
+		if f.Default != nil {
+			fields = append(fields, f.Name.String()+" = "+f.Default.String())
+		} else {
+			fields = append(fields, f.Name.String())
+		}
+	}
+	return "struct " + ss.Name.String() + " { " + strings.Join(fields, ", ") + " }"
+}
+
 // Identifier
 type Identifier struct {
 	Token token.Token
@@ -156,6 +321,7 @@ type Identifier struct {
 
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Position() (int, int) { return i.Token.Line, i.Token.Col }
 func (i *Identifier) String() string       { return i.Value }
 
 // IntegerLiteral
@@ -166,6 +332,7 @@ type IntegerLiteral struct {
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Position() (int, int) { return il.Token.Line, il.Token.Col }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 // FloatLiteral
@@ -176,6 +343,7 @@ type FloatLiteral struct {
 
 func (fl *FloatLiteral) expressionNode()      {}
 func (fl *FloatLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FloatLiteral) Position() (int, int) { return fl.Token.Line, fl.Token.Col }
 func (fl *FloatLiteral) String() string       { return fl.Token.Literal }
 
 // StringLiteral - includes interpolation parts
@@ -189,10 +357,12 @@ type StringPart struct {
 	IsExpr bool
 	Text   string
 	Expr   Expression
+	Spec   string // format spec after a colon, as in {price:.2f}
 }
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Position() (int, int) { return sl.Token.Line, sl.Token.Col }
 func (sl *StringLiteral) String() string       { return "\"" + sl.Value + "\"" }
 
 // BooleanLiteral
@@ -203,6 +373,7 @@ type Boolean struct {
 
 func (b *Boolean) expressionNode()      {}
 func (b *Boolean) TokenLiteral() string { return b.Token.Literal }
+func (b *Boolean) Position() (int, int) { return b.Token.Line, b.Token.Col }
 func (b *Boolean) String() string       { return b.Token.Literal }
 
 // NullLiteral
@@ -212,17 +383,47 @@ type NullLiteral struct {
 
 func (nl *NullLiteral) expressionNode()      {}
 func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) Position() (int, int) { return nl.Token.Line, nl.Token.Col }
 func (nl *NullLiteral) String() string       { return "null" }
 
 // RegexLiteral
 type RegexLiteral struct {
 	Token   token.Token
 	Pattern string
+	Flags   string
 }
 
 func (rl *RegexLiteral) expressionNode()      {}
 func (rl *RegexLiteral) TokenLiteral() string { return rl.Token.Literal }
-func (rl *RegexLiteral) String() string       { return "/" + rl.Pattern + "/" }
+func (rl *RegexLiteral) Position() (int, int) { return rl.Token.Line, rl.Token.Col }
+func (rl *RegexLiteral) String() string       { return "/" + rl.Pattern + "/" + rl.Flags }
+
+// SubstituteLiteral is s/pattern/replacement/flags on the right of ~
+type SubstituteLiteral struct {
+	Token       token.Token
+	Pattern     string
+	Replacement string
+	Flags       string
+}
+
+func (sl *SubstituteLiteral) expressionNode()      {}
+func (sl *SubstituteLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *SubstituteLiteral) Position() (int, int) { return sl.Token.Line, sl.Token.Col }
+func (sl *SubstituteLiteral) String() string {
+	return "s/" + sl.Pattern + "/" + sl.Replacement + "/" + sl.Flags
+}
+
+// TransliterateLiteral is tr/from/to/ on the right of ~
+type TransliterateLiteral struct {
+	Token token.Token
+	From  string
+	To    string
+}
+
+func (tl *TransliterateLiteral) expressionNode()      {}
+func (tl *TransliterateLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TransliterateLiteral) Position() (int, int) { return tl.Token.Line, tl.Token.Col }
+func (tl *TransliterateLiteral) String() string       { return "tr/" + tl.From + "/" + tl.To + "/" }
 
 // ArrayLiteral
 type ArrayLiteral struct {
@@ -232,6 +433,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Position() (int, int) { return al.Token.Line, al.Token.Col }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 	elements := []string{}
@@ -244,19 +446,70 @@ func (al *ArrayLiteral) String() string {
 	return out.String()
 }
 
+// ArrayPattern: [a, b, ...rest] as the target of let, for or a parameter.
+// Elements are Identifiers or nested patterns.
+type ArrayPattern struct {
+	Token    token.Token
+	Elements []Expression
+	Rest     *Identifier // optional
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Position() (int, int) { return ap.Token.Line, ap.Token.Col }
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// MapPattern: {name, age: years, ...rest} takes keys out of a map or
+// fields out of a struct. Values[i] is the pattern Keys[i] is bound to.
+type MapPattern struct {
+	Token  token.Token
+	Keys   []string
+	Values []Expression
+	Rest   *Identifier // optional
+}
+
+func (mp *MapPattern) expressionNode()      {}
+func (mp *MapPattern) TokenLiteral() string { return mp.Token.Literal }
+func (mp *MapPattern) Position() (int, int) { return mp.Token.Line, mp.Token.Col }
+func (mp *MapPattern) String() string {
+	entries := []string{}
+	for i, key := range mp.Keys {
+		if ident, ok := mp.Values[i].(*Identifier); ok && ident.Value == key {
+			entries = append(entries, key)
+		} else {
+			entries = append(entries, key+": "+mp.Values[i].String())
+		}
+	}
+	if mp.Rest != nil {
+		entries = append(entries, "..."+mp.Rest.String())
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
 // MapLiteral: {key: value, ...}
 type MapLiteral struct {
 	Token token.Token
 	Pairs map[Expression]Expression
+	Keys  []Expression // the keys of Pairs in source order
 }
 
 func (ml *MapLiteral) expressionNode()      {}
 func (ml *MapLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MapLiteral) Position() (int, int) { return ml.Token.Line, ml.Token.Col }
 func (ml *MapLiteral) String() string {
 	var out bytes.Buffer
 	pairs := []string{}
-	for key, value := range ml.Pairs {
-		pairs = append(pairs, key.String()+": "+value.String())
+	for _, key := range ml.Keys {
+		pairs = append(pairs, key.String()+": "+ml.Pairs[key].String())
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -264,17 +517,24 @@ func (ml *MapLiteral) String() string {
 	return out.String()
 }
 
-// RangeLiteral: start..end
+// RangeLiteral: start..end, start..=end, start..end step n
 type RangeLiteral struct {
-	Token token.Token
-	Start Expression
-	End   Expression
+	Token     token.Token
+	Start     Expression
+	End       Expression
+	Step      Expression // nil without a step
+	Inclusive bool
 }
 
 func (rl *RangeLiteral) expressionNode()      {}
 func (rl *RangeLiteral) TokenLiteral() string { return rl.Token.Literal }
+func (rl *RangeLiteral) Position() (int, int) { return rl.Token.Line, rl.Token.Col }
 func (rl *RangeLiteral) String() string {
-	return rl.Start.String() + ".." + rl.End.String()
+	s := rl.Start.String() + rl.Token.Literal + rl.End.String()
+	if rl.Step != nil {
+		s += " step " + rl.Step.String()
+	}
+	return s
 }
 
 // PrefixExpression: !expr, -expr
@@ -286,6 +546,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Position() (int, int) { return pe.Token.Line, pe.Token.Col }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -305,6 +566,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Position() (int, int) { return ie.Token.Line, ie.Token.Col }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -325,6 +587,7 @@ type IfExpression struct {
 
 func (ie *IfExpression) expressionNode()      {}
 func (ie *IfExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IfExpression) Position() (int, int) { return ie.Token.Line, ie.Token.Col }
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("if ")
@@ -338,35 +601,75 @@ func (ie *IfExpression) String() string {
 	return out.String()
 }
 
+// MatchExpression: match value { pattern => result, ... }
+type MatchExpression struct {
+	Token   token.Token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+// MatchArm holds one pattern and either an expression or a block body
+type MatchArm struct {
+	Pattern Expression
+	Body    Node
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Position() (int, int) { return me.Token.Line, me.Token.Col }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.Pattern.String()+" => "+a.Body.String())
+	}
+	out.WriteString("match ")
+	out.WriteString(me.Subject.String())
+	out.WriteString(" { ")
+	out.WriteString(strings.Join(arms, ", "))
+	out.WriteString(" }")
+	return out.String()
+}
+
 // FunctionLiteral
 type FunctionLiteral struct {
 	Token      token.Token
-	Name       string // optional, for named functions
+	Name       string      // optional, for named functions
+	Receiver   *Identifier // set for methods: fn Point.dist(self, other)
 	Parameters []*FunctionParam
 	Body       *BlockStatement
+	Generator  bool // the body yields, so calling it returns an iterator
 }
 
 type FunctionParam struct {
 	Name    *Identifier
 	Default Expression // optional default value
+	Pattern Expression // optional, destructures the argument; Name is then the pattern's text
+	Rest    bool       // ...name, collecting extra arguments into an array
 }
 
 func (fl *FunctionLiteral) expressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
+func (fl *FunctionLiteral) Position() (int, int) { return fl.Token.Line, fl.Token.Col }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 	params := []string{}
 	for _, p := range fl.Parameters {
-		if p.Default != nil {
+		if p.Rest {
+			params = append(params, "..."+p.Name.String())
+		} else if p.Default != nil {
 			params = append(params, p.Name.String()+" = "+p.Default.String())
 		} else {
 			params = append(params, p.Name.String())
 		}
 	}
 	out.WriteString("fn")
-	if fl.Name != "" {
-		out.WriteString(" " + fl.Name)
+	if fl.Receiver != nil {
+		out.WriteString(" " + fl.Receiver.String() + ".")
+	} else if fl.Name != "" {
+		out.WriteString(" ")
 	}
+	out.WriteString(fl.Name)
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") ")
@@ -382,12 +685,23 @@ type CallExpression struct {
 }
 
 type CallArg struct {
-	Name  string // optional, for named args
-	Value Expression
+	Name   string // optional, for named args
+	Spread string // "..." for f(...arr), "**" for f(**opts)
+	Value  Expression
+}
+
+// Key is how the argument is passed: its name, the spread operator, or
+// "" for a plain positional argument
+func (ca CallArg) Key() string {
+	if ca.Spread != "" {
+		return ca.Spread
+	}
+	return ca.Name
 }
 
 func (ce *CallExpression) expressionNode()      {}
 func (ce *CallExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CallExpression) Position() (int, int) { return ce.Function.Position() }
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 	args := []string{}
@@ -395,7 +709,7 @@ func (ce *CallExpression) String() string {
 		if a.Name != "" {
 			args = append(args, a.Name+" = "+a.Value.String())
 		} else {
-			args = append(args, a.Value.String())
+			args = append(args, a.Spread+a.Value.String())
 		}
 	}
 	out.WriteString(ce.Function.String())
@@ -414,6 +728,7 @@ type IndexExpression struct {
 
 func (ie *IndexExpression) expressionNode()      {}
 func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) Position() (int, int) { return ie.Token.Line, ie.Token.Col }
 func (ie *IndexExpression) String() string {
 	var out bytes.Buffer
 	out.WriteString("(")
@@ -433,6 +748,7 @@ type MemberExpression struct {
 
 func (me *MemberExpression) expressionNode()      {}
 func (me *MemberExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MemberExpression) Position() (int, int) { return me.Token.Line, me.Token.Col }
 func (me *MemberExpression) String() string {
 	return me.Object.String() + "." + me.Member.String()
 }
@@ -446,6 +762,7 @@ type PipeExpression struct {
 
 func (pe *PipeExpression) expressionNode()      {}
 func (pe *PipeExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PipeExpression) Position() (int, int) { return pe.Token.Line, pe.Token.Col }
 func (pe *PipeExpression) String() string {
 	return pe.Left.String() + " |> " + pe.Right.String()
 }
@@ -459,6 +776,7 @@ type AssignExpression struct {
 
 func (ae *AssignExpression) expressionNode()      {}
 func (ae *AssignExpression) TokenLiteral() string { return ae.Token.Literal }
+func (ae *AssignExpression) Position() (int, int) { return ae.Token.Line, ae.Token.Col }
 func (ae *AssignExpression) String() string {
 	return ae.Name.String() + " = " + ae.Value.String()
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"pearl/compiler"
+	"pearl/evaluator"
+	"pearl/lexer"
+	"pearl/object"
+	"pearl/parser"
+	"pearl/vm"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/*.out with the evaluator's output")
+
+// TestBackends runs every script in testdata and examples on the evaluator
+// and on the vm, and checks both print the same thing and fail the same
+// way. Scripts with a .out file next to them must also match it.
+func TestBackends(t *testing.T) {
+	scripts, _ := filepath.Glob("testdata/*.pearl")
+	examples, _ := filepath.Glob("examples/*.pearl")
+	scripts = append(scripts, examples...)
+	if len(scripts) == 0 {
+		t.Fatal("no scripts found")
+	}
+
+	for _, script := range scripts {
+		t.Run(script, func(t *testing.T) {
+			src, err := os.ReadFile(script)
+			if err != nil {
+				t.Fatal(err)
+			}
+			evalOut := runScript(t, string(src), script, false)
+			vmOut := runScript(t, string(src), script, true)
+			if evalOut != vmOut {
+				t.Errorf("backends differ\n--- evaluator\n%s--- vm\n%s", evalOut, vmOut)
+			}
+
+			golden := strings.TrimSuffix(script, ".pearl") + ".out"
+			if *update && strings.HasPrefix(script, "testdata") {
+				if err := os.WriteFile(golden, []byte(evalOut), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				return
+			}
+			if evalOut != string(want) {
+				t.Errorf("output doesn't match %s\n--- got\n%s--- want\n%s", golden, evalOut, want)
+			}
+		})
+	}
+}
+
+// TestTwoVMs checks a builtin calls a closure back on the vm that made
+// it, not on another vm made since
+func TestTwoVMs(t *testing.T) {
+	compile := func(src string) *compiler.Bytecode {
+		p := parser.New(lexer.New(src))
+		program := p.ParseProgram()
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatal(err)
+		}
+		return comp.Bytecode()
+	}
+
+	first := vm.New(compile(`let k = 10
+let out = map([1, 2], fn(x) { x + k })`))
+	vm.New(compile(`let a = 1
+let b = 2
+let c = map([1], fn(x) { x })`)).Run()
+	if result := first.Run(); result != evaluator.NULL {
+		t.Fatalf("got %s", result.Inspect())
+	}
+	if out, _ := first.Global("out"); out == nil || out.Inspect() != "[11, 12]" {
+		t.Errorf("got %v, want [11, 12]", out)
+	}
+}
+
+// TestGeneratorsEnd checks that generators left part way don't keep their
+// goroutines once nothing reads them
+func TestGeneratorsEnd(t *testing.T) {
+	src := `
+fn nat() {
+    let i = 0
+    while true {
+        yield i
+        i = i + 1
+    }
+}
+for k in 0..1000 {
+    take(nat(), 1) |> collect
+    zip(nat(), [1]) |> collect
+    for v in nat() { break }
+    try {
+        for v in nat() { nope }
+    } catch err {}
+}
+`
+	for _, useVM := range []bool{false, true} {
+		before := runtime.NumGoroutine()
+		if out := runScript(t, src, "gen.pearl", useVM); out != "" {
+			t.Fatalf("vm=%v: %s", useVM, out)
+		}
+		// the goroutines unwind on their own after being told to stop
+		deadline := time.Now().Add(2 * time.Second)
+		for runtime.NumGoroutine() > before+10 && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := runtime.NumGoroutine(); n > before+10 {
+			t.Errorf("vm=%v: %d goroutines left running, had %d", useVM, n, before)
+		}
+	}
+}
+
+// runScript runs src the way main does and returns what it printed,
+// followed by the uncaught error and its stack trace if there was one
+func runScript(t *testing.T, src, path string, useVM bool) (out string) {
+	t.Helper()
+	var buf bytes.Buffer
+	saved := evaluator.Stdout
+	evaluator.Stdout = &buf
+	defer func() {
+		evaluator.Stdout = saved
+		if r := recover(); r != nil {
+			out = buf.String() + fmt.Sprintf("panic: %v\n", r)
+		}
+	}()
+
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return strings.Join(p.Errors(), "\n") + "\n"
+	}
+
+	var result object.Object
+	if useVM {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			return fmt.Sprintf("compile error: %s\n", err)
+		}
+		machine := vm.New(comp.Bytecode())
+		machine.SetFile(path)
+		result = machine.Run()
+	} else {
+		env := object.NewEnvironment()
+		env.SetFile(path)
+		result = evaluator.Eval(program, env)
+	}
+
+	if errObj, ok := result.(*object.Error); ok {
+		if errObj.IsExit() {
+			fmt.Fprintf(&buf, "exit %d\n", errObj.Code)
+		} else {
+			fmt.Fprintln(&buf, errObj.Inspect())
+			fmt.Fprint(&buf, errObj.StackTrace())
+		}
+	}
+	return buf.String()
+}
@@ -0,0 +1,290 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), len(def.OperandWidths))
+	}
+
+	out := def.Name
+	for _, o := range operands {
+		out += fmt.Sprintf(" %d", o)
+	}
+	return out
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+	OpDup
+	OpNull
+	OpTrue
+	OpFalse
+
+	// arithmetic and comparison
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpConcat
+	OpEqual
+	OpNotEqual
+	OpLess
+	OpGreater
+	OpLessEqual
+	OpGreaterEqual
+	OpMatch
+	OpNotMatch
+	OpMinus
+	OpBang
+
+	// control flow
+	OpJump
+	OpJumpNotTruthy
+
+	// variables
+	OpGetGlobal
+	OpSetGlobal
+	OpDefineGlobal
+	OpGetLocal
+	OpSetLocal
+	OpDefineLocal
+	OpGetFree
+	OpSetFree
+	OpCaptureGlobal
+	OpCaptureLocal
+	OpCaptureFree
+
+	// values
+	OpArray
+	OpMap
+	OpRange
+	OpInterpolate
+	OpFormat
+	OpIndex
+	OpSetIndex
+	OpGetMember
+	OpSetMember
+	OpUnpackArray
+	OpUnpackMap
+
+	// functions
+	OpClosure
+	OpCall
+	OpCallNamed
+	OpCallMethod
+	OpReturnValue
+	OpYield
+	OpDefault
+	OpStruct
+	OpDefineMethod
+
+	// loops
+	OpIter
+	OpIterNext
+	OpIterClose
+
+	// errors
+	OpTry
+	OpEndTry
+	OpRaise
+
+	// match patterns
+	OpMatchLen
+	OpInRange
+	OpRegexGroups
+	OpMatchEqual
+
+	// modules
+	OpImport
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{}},
+	OpNull:     {"OpNull", []int{}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpConcat:       {"OpConcat", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpLess:         {"OpLess", []int{}},
+	OpGreater:      {"OpGreater", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpMatch:        {"OpMatch", []int{}},
+	OpNotMatch:     {"OpNotMatch", []int{}},
+	OpMinus:        {"OpMinus", []int{}},
+	OpBang:         {"OpBang", []int{}},
+
+	OpJump:          {"OpJump", []int{2}},
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+
+	// define ops carry a flag saying whether the slot gets a fresh cell
+	// because a closure captures it
+	OpGetGlobal:     {"OpGetGlobal", []int{2}},
+	OpSetGlobal:     {"OpSetGlobal", []int{2}},
+	OpDefineGlobal:  {"OpDefineGlobal", []int{2, 1}},
+	OpGetLocal:      {"OpGetLocal", []int{1}},
+	OpSetLocal:      {"OpSetLocal", []int{1}},
+	OpDefineLocal:   {"OpDefineLocal", []int{1, 1}},
+	OpGetFree:       {"OpGetFree", []int{1}},
+	OpSetFree:       {"OpSetFree", []int{1}},
+	OpCaptureGlobal: {"OpCaptureGlobal", []int{2}},
+	OpCaptureLocal:  {"OpCaptureLocal", []int{1}},
+	OpCaptureFree:   {"OpCaptureFree", []int{1}},
+
+	OpArray:       {"OpArray", []int{2}},
+	OpMap:         {"OpMap", []int{2}},
+	OpRange:       {"OpRange", []int{1}}, // 1 if inclusive, +2 if a step is on the stack
+	OpInterpolate: {"OpInterpolate", []int{2}},
+	OpFormat:      {"OpFormat", []int{2}}, // takes a constant holding the spec
+	OpIndex:       {"OpIndex", []int{}},
+	OpSetIndex:    {"OpSetIndex", []int{}},
+
+	// member ops take the member name as a constant
+	OpGetMember: {"OpGetMember", []int{2}},
+	OpSetMember: {"OpSetMember", []int{2}},
+
+	// unpack ops replace a value with its parts for a destructuring
+	// pattern, the first part on top. They take the element count or a
+	// constant holding the keys, and whether there's a ...rest.
+	OpUnpackArray: {"OpUnpackArray", []int{2, 1}},
+	OpUnpackMap:   {"OpUnpackMap", []int{2, 1}},
+
+	// OpCallNamed's second operand is a constant holding the argument names.
+	// OpCallMethod takes the method name, the argument count, the names, and
+	// 1 if a local function of the method's name is on the stack above the
+	// arguments.
+	OpClosure:     {"OpClosure", []int{2, 1}},
+	OpCall:        {"OpCall", []int{1}},
+	OpCallNamed:   {"OpCallNamed", []int{1, 2}},
+	OpCallMethod:  {"OpCallMethod", []int{2, 1, 2, 1}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpYield:       {"OpYield", []int{}},
+	OpDefault:     {"OpDefault", []int{1, 2}},
+
+	// OpStruct takes a constant holding the struct's name and fields and
+	// pops its constructor; OpDefineMethod takes the method name
+	OpStruct:       {"OpStruct", []int{2}},
+	OpDefineMethod: {"OpDefineMethod", []int{2}},
+
+	OpIter:      {"OpIter", []int{}},
+	OpIterNext:  {"OpIterNext", []int{1, 2}}, // the flag pushes the index or map key too
+	OpIterClose: {"OpIterClose", []int{}},
+
+	OpTry:    {"OpTry", []int{2}},
+	OpEndTry: {"OpEndTry", []int{}},
+	OpRaise:  {"OpRaise", []int{2}},
+
+	OpMatchLen:    {"OpMatchLen", []int{2}},
+	OpInRange:     {"OpInRange", []int{}},
+	OpRegexGroups: {"OpRegexGroups", []int{}},
+	OpMatchEqual:  {"OpMatchEqual", []int{}},
+
+	// OpImport takes a constant holding the path and pushes the module
+	OpImport: {"OpImport", []int{2}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes an instruction, operands are big endian
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
@@ -0,0 +1,145 @@
+package compiler
+
+import "pearl/ast"
+
+// capturedNames returns every identifier used inside a function literal
+// nested somewhere in node, counting method names since x.name() can call
+// a function named name. Variables with these names get cells so closures
+// share them with the scope that defined them. It over-approximates when
+// names are shadowed, which only costs an extra indirection.
+func capturedNames(node ast.Node) map[string]bool {
+	names := make(map[string]bool)
+	walk(node, func(n ast.Node) {
+		if fn, ok := n.(*ast.FunctionLiteral); ok {
+			walk(fn, func(inner ast.Node) {
+				switch inner := inner.(type) {
+				case *ast.Identifier:
+					names[inner.Value] = true
+				case *ast.CallExpression:
+					if me, ok := inner.Function.(*ast.MemberExpression); ok {
+						names[me.Member.Value] = true
+					}
+				}
+			})
+		}
+	})
+	return names
+}
+
+// walk calls f for node and all of its children, depth first
+func walk(node ast.Node, f func(ast.Node)) {
+	if node == nil {
+		return
+	}
+	f(node)
+
+	switch n := node.(type) {
+	case *ast.Program:
+		for _, s := range n.Statements {
+			walk(s, f)
+		}
+	case *ast.BlockStatement:
+		if n == nil {
+			return
+		}
+		for _, s := range n.Statements {
+			walk(s, f)
+		}
+	case *ast.ExpressionStatement:
+		walk(n.Expression, f)
+	case *ast.LetStatement:
+		walk(n.Pattern, f)
+		walk(n.Value, f)
+	case *ast.ReturnStatement:
+		walk(n.ReturnValue, f)
+	case *ast.YieldStatement:
+		walk(n.Value, f)
+	case *ast.ForStatement:
+		if n.Index != nil {
+			walk(n.Index, f)
+		}
+		walk(n.Variable, f)
+		walk(n.Iterable, f)
+		walk(n.Body, f)
+	case *ast.WhileStatement:
+		walk(n.Condition, f)
+		walk(n.Body, f)
+	case *ast.TryStatement:
+		walk(n.Body, f)
+		walk(n.Catch, f)
+	case *ast.StructStatement:
+		walk(n.Init, f)
+	case *ast.StringLiteral:
+		for _, part := range n.Parts {
+			if part.IsExpr {
+				walk(part.Expr, f)
+			}
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range n.Elements {
+			walk(el, f)
+		}
+	case *ast.MapLiteral:
+		for _, k := range n.Keys {
+			walk(k, f)
+			walk(n.Pairs[k], f)
+		}
+	case *ast.ArrayPattern:
+		for _, el := range n.Elements {
+			walk(el, f)
+		}
+		if n.Rest != nil {
+			walk(n.Rest, f)
+		}
+	case *ast.MapPattern:
+		for _, v := range n.Values {
+			walk(v, f)
+		}
+		if n.Rest != nil {
+			walk(n.Rest, f)
+		}
+	case *ast.RangeLiteral:
+		walk(n.Start, f)
+		walk(n.End, f)
+		walk(n.Step, f)
+	case *ast.PrefixExpression:
+		walk(n.Right, f)
+	case *ast.InfixExpression:
+		walk(n.Left, f)
+		walk(n.Right, f)
+	case *ast.IfExpression:
+		walk(n.Condition, f)
+		walk(n.Consequence, f)
+		if n.Alternative != nil {
+			walk(n.Alternative, f)
+		}
+	case *ast.MatchExpression:
+		walk(n.Subject, f)
+		for _, arm := range n.Arms {
+			walk(arm.Pattern, f)
+			walk(arm.Body, f)
+		}
+	case *ast.FunctionLiteral:
+		for _, p := range n.Parameters {
+			walk(p.Pattern, f)
+			walk(p.Default, f)
+		}
+		walk(n.Body, f)
+	case *ast.CallExpression:
+		walk(n.Function, f)
+		for _, a := range n.Arguments {
+			walk(a.Value, f)
+		}
+	case *ast.IndexExpression:
+		walk(n.Left, f)
+		walk(n.Index, f)
+	case *ast.MemberExpression:
+		walk(n.Object, f)
+	case *ast.PipeExpression:
+		walk(n.Left, f)
+		walk(n.Right, f)
+	case *ast.AssignExpression:
+		walk(n.Name, f)
+		walk(n.Value, f)
+	}
+}
@@ -0,0 +1,1180 @@
+package compiler
+
+import (
+	"fmt"
+	"path/filepath"
+	"pearl/ast"
+	"pearl/code"
+	"pearl/object"
+	"strings"
+)
+
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Positions    []object.SourcePos
+	NumGlobals   int
+	GlobalNames  []string
+}
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// source position of the node being compiled, recorded against
+	// every instruction so runtime errors point at the right place
+	line, col int
+
+	hiddenCount int
+}
+
+// CompilationScope holds the code for one function being compiled
+type CompilationScope struct {
+	instructions code.Instructions
+	positions    []object.SourcePos
+	loops        []*loop
+	tryDepth     int
+}
+
+type loop struct {
+	label          string
+	continueTarget int
+	breaks         []int
+	tryDepth       int
+	iter           *Symbol // the iterator of a for loop
+}
+
+func New() *Compiler {
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: NewSymbolTable(),
+		scopes:      []CompilationScope{{}},
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	root := c.symbolTable.root()
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Positions:    c.scopes[c.scopeIndex].positions,
+		NumGlobals:   root.NumDefinitions(),
+		GlobalNames:  root.Names(),
+	}
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	if line, col := node.Position(); line > 0 {
+		prevLine, prevCol := c.line, c.col
+		c.line, c.col = line, col
+		defer func() { c.line, c.col = prevLine, prevCol }()
+	}
+
+	switch node := node.(type) {
+	case *ast.Program:
+		c.symbolTable.captured = capturedNames(node)
+		for _, s := range node.Statements {
+			if err := c.compileStatement(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+
+	case *ast.StringLiteral:
+		return c.compileStringLiteral(node)
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.NullLiteral:
+		c.emit(code.OpNull)
+
+	case *ast.RegexLiteral:
+		re, err := object.NewRegex(node.Pattern, node.Flags)
+		if err != nil {
+			c.emitRaise("invalid regex pattern: %s", err)
+			return nil
+		}
+		c.emit(code.OpConstant, c.addConstant(re))
+
+	case *ast.SubstituteLiteral:
+		subst, err := object.NewSubstitution(node.Pattern, node.Replacement, node.Flags)
+		if err != nil {
+			c.emitRaise("invalid regex pattern: %s", err)
+			return nil
+		}
+		c.emit(code.OpConstant, c.addConstant(subst))
+
+	case *ast.TransliterateLiteral:
+		tr, err := object.NewTransliteration(node.From, node.To)
+		if err != nil {
+			c.emitRaise("%s", err)
+			return nil
+		}
+		c.emit(code.OpConstant, c.addConstant(tr))
+
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.MapLiteral:
+		for _, k := range node.Keys {
+			if err := c.Compile(k); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Pairs[k]); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpMap, len(node.Keys))
+
+	case *ast.RangeLiteral:
+		if err := c.Compile(node.Start); err != nil {
+			return err
+		}
+		if err := c.Compile(node.End); err != nil {
+			return err
+		}
+		flags := 0
+		if node.Inclusive {
+			flags |= 1
+		}
+		if node.Step != nil {
+			if err := c.Compile(node.Step); err != nil {
+				return err
+			}
+			flags |= 2
+		}
+		c.emit(code.OpRange, flags)
+
+	case *ast.Identifier:
+		c.loadSymbol(c.resolve(node.Value))
+
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "-":
+			c.emit(code.OpMinus)
+		case "!", "not":
+			c.emit(code.OpBang)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		return c.compileInfixExpression(node)
+
+	case *ast.IfExpression:
+		return c.compileIfExpression(node)
+
+	case *ast.MatchExpression:
+		return c.compileMatchExpression(node)
+
+	case *ast.FunctionLiteral:
+		return c.compileFunctionLiteral(node)
+
+	case *ast.CallExpression:
+		return c.compileCallExpression(node)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+
+	case *ast.PipeExpression:
+		return c.compilePipeExpression(node)
+
+	case *ast.MemberExpression:
+		if err := c.Compile(node.Object); err != nil {
+			return err
+		}
+		c.emit(code.OpGetMember, c.addConstant(&object.String{Value: node.Member.Value}))
+
+	case *ast.AssignExpression:
+		return c.compileAssignExpression(node)
+
+	default:
+		return fmt.Errorf("the vm does not support %T yet", node)
+	}
+
+	return nil
+}
+
+// compileStatement compiles s so that it leaves nothing on the stack
+func (c *Compiler) compileStatement(s ast.Statement) error {
+	if line, col := s.Position(); line > 0 {
+		prevLine, prevCol := c.line, c.col
+		c.line, c.col = line, col
+		defer func() { c.line, c.col = prevLine, prevCol }()
+	}
+
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		if err := c.Compile(s.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.LetStatement:
+		if err := c.Compile(s.Value); err != nil {
+			return err
+		}
+		if s.Pattern != nil {
+			return c.compileBinding(s.Pattern)
+		}
+		c.defineSymbol(c.symbolTable.Define(s.Name.Value))
+
+	case *ast.ReturnStatement:
+		if s.ReturnValue == nil {
+			c.emit(code.OpNull)
+		} else if err := c.Compile(s.ReturnValue); err != nil {
+			return err
+		}
+		c.stopIterators(c.scopes[c.scopeIndex].loops)
+		c.emit(code.OpReturnValue)
+
+	case *ast.YieldStatement:
+		if s.Value == nil {
+			c.emit(code.OpNull)
+		} else if err := c.Compile(s.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpYield)
+
+	case *ast.BlockStatement:
+		for _, inner := range s.Statements {
+			if err := c.compileStatement(inner); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ForStatement:
+		return c.compileForStatement(s)
+
+	case *ast.WhileStatement:
+		return c.compileWhileStatement(s)
+
+	case *ast.TryStatement:
+		return c.compileTryStatement(s, false)
+
+	case *ast.StructStatement:
+		if err := c.compileStructStatement(s); err != nil {
+			return err
+		}
+		c.defineSymbol(c.symbolTable.Define(s.Name.Value))
+
+	case *ast.BreakStatement:
+		return c.compileLoopJump(s.Label, true)
+
+	case *ast.ContinueStatement:
+		return c.compileLoopJump(s.Label, false)
+
+	case *ast.ImportStatement:
+		return c.compileImportStatement(s, false)
+
+	default:
+		return fmt.Errorf("the vm does not support %T yet", s)
+	}
+
+	return nil
+}
+
+// compileBlockValue compiles a block that leaves exactly one value on the
+// stack: the value of its last statement, like evalBlockStatement
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	if len(block.Statements) == 0 {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		if err := c.compileStatement(s); err != nil {
+			return err
+		}
+	}
+
+	switch s := block.Statements[last].(type) {
+	case *ast.ExpressionStatement:
+		return c.Compile(s.Expression)
+
+	case *ast.LetStatement:
+		if err := c.Compile(s.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpDup)
+		if s.Pattern != nil {
+			return c.compileBinding(s.Pattern)
+		}
+		c.defineSymbol(c.symbolTable.Define(s.Name.Value))
+		return nil
+
+	case *ast.StructStatement:
+		if err := c.compileStructStatement(s); err != nil {
+			return err
+		}
+		c.emit(code.OpDup)
+		c.defineSymbol(c.symbolTable.Define(s.Name.Value))
+		return nil
+
+	case *ast.TryStatement:
+		return c.compileTryStatement(s, true)
+
+	case *ast.ImportStatement:
+		return c.compileImportStatement(s, true)
+
+	default:
+		if err := c.compileStatement(s); err != nil {
+			return err
+		}
+		c.emit(code.OpNull)
+		return nil
+	}
+}
+
+func (c *Compiler) compileStringLiteral(node *ast.StringLiteral) error {
+	if len(node.Parts) == 0 {
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+		return nil
+	}
+
+	for _, part := range node.Parts {
+		if part.IsExpr {
+			if err := c.Compile(part.Expr); err != nil {
+				return err
+			}
+			if part.Spec != "" {
+				c.emit(code.OpFormat, c.addConstant(&object.String{Value: part.Spec}))
+			}
+		} else {
+			c.emit(code.OpConstant, c.addConstant(&object.String{Value: part.Text}))
+		}
+	}
+	c.emit(code.OpInterpolate, len(node.Parts))
+	return nil
+}
+
+var infixOps = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"++": code.OpConcat,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	"<":  code.OpLess,
+	">":  code.OpGreater,
+	"<=": code.OpLessEqual,
+	">=": code.OpGreaterEqual,
+	"~":  code.OpMatch,
+	"!~": code.OpNotMatch,
+}
+
+func (c *Compiler) compileInfixExpression(node *ast.InfixExpression) error {
+	if node.Operator == "and" || node.Operator == "or" {
+		// yield the deciding operand itself, like evalLogicalExpression
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		c.emit(code.OpDup)
+		var jump int
+		if node.Operator == "and" {
+			jump = c.emit(code.OpJumpNotTruthy, 9999)
+		} else {
+			// jump past the right side when left is truthy
+			notTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+			jump = c.emit(code.OpJump, 9999)
+			c.changeOperand(notTruthy, len(c.currentInstructions()))
+		}
+		c.emit(code.OpPop)
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.changeOperand(jump, len(c.currentInstructions()))
+		return nil
+	}
+
+	op, ok := infixOps[node.Operator]
+	if !ok {
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	c.emit(op)
+	return nil
+}
+
+func (c *Compiler) compileIfExpression(node *ast.IfExpression) error {
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+	if err := c.compileBlockValue(node.Consequence); err != nil {
+		return err
+	}
+	jump := c.emit(code.OpJump, 9999)
+
+	c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+	if node.Alternative == nil {
+		c.emit(code.OpNull)
+	} else if err := c.compileBlockValue(node.Alternative); err != nil {
+		return err
+	}
+	c.changeOperand(jump, len(c.currentInstructions()))
+	return nil
+}
+
+func (c *Compiler) compileMatchExpression(node *ast.MatchExpression) error {
+	if err := c.Compile(node.Subject); err != nil {
+		return err
+	}
+	subject := c.symbolTable.Define(c.hiddenName())
+	c.defineSymbol(subject)
+
+	var ends []int
+	for _, arm := range node.Arms {
+		c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+
+		var fails []int
+		if err := c.compilePattern(arm.Pattern, subject, &fails); err != nil {
+			return err
+		}
+
+		var err error
+		if block, ok := arm.Body.(*ast.BlockStatement); ok {
+			err = c.compileBlockValue(block)
+		} else {
+			err = c.Compile(arm.Body)
+		}
+		if err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(code.OpJump, 9999))
+
+		c.symbolTable = c.symbolTable.Outer
+		for _, f := range fails {
+			c.changeOperand(f, len(c.currentInstructions()))
+		}
+	}
+
+	c.emit(code.OpNull)
+	for _, e := range ends {
+		c.changeOperand(e, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compilePattern emits code that falls through when the value in subject
+// matches pattern, binding names as it goes, and otherwise jumps to one of
+// the addresses patched into fails
+func (c *Compiler) compilePattern(pattern ast.Expression, subject Symbol, fails *[]int) error {
+	switch pat := pattern.(type) {
+	case *ast.Identifier:
+		if pat.Value != "_" {
+			c.loadSymbol(subject)
+			c.defineSymbol(c.symbolTable.Define(pat.Value))
+		}
+
+	case *ast.ArrayLiteral:
+		c.loadSymbol(subject)
+		c.emit(code.OpMatchLen, len(pat.Elements))
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+		for i, el := range pat.Elements {
+			c.loadSymbol(subject)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(i)}))
+			c.emit(code.OpIndex)
+			elem := c.symbolTable.Define(c.hiddenName())
+			c.defineSymbol(elem)
+			if err := c.compilePattern(el, elem, fails); err != nil {
+				return err
+			}
+		}
+
+	case *ast.RangeLiteral:
+		c.loadSymbol(subject)
+		if err := c.Compile(pat); err != nil {
+			return err
+		}
+		c.emit(code.OpInRange)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+
+	case *ast.RegexLiteral:
+		re, err := object.NewRegex(pat.Pattern, pat.Flags)
+		if err != nil {
+			c.emitRaise("invalid regex pattern: %s", err)
+			return nil
+		}
+		c.loadSymbol(subject)
+		c.emit(code.OpConstant, c.addConstant(re))
+		c.emit(code.OpRegexGroups)
+		found := c.symbolTable.Define(c.hiddenName())
+		c.defineSymbol(found)
+		c.loadSymbol(found)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+		c.loadSymbol(found)
+		groups := c.symbolTable.Define("groups")
+		c.defineSymbol(groups)
+		for i, name := range re.Regexp.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			c.loadSymbol(groups)
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: int64(i)}))
+			c.emit(code.OpIndex)
+			c.defineSymbol(c.symbolTable.Define(name))
+		}
+
+	default:
+		c.loadSymbol(subject)
+		if err := c.Compile(pattern); err != nil {
+			return err
+		}
+		c.emit(code.OpMatchEqual)
+		*fails = append(*fails, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+	return nil
+}
+
+func (c *Compiler) compileForStatement(node *ast.ForStatement) error {
+	if err := c.Compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+	iter := c.symbolTable.Define(c.hiddenName())
+	c.defineSymbol(iter)
+
+	withIndex := 0
+	if node.Index != nil {
+		withIndex = 1
+	}
+
+	loopStart := len(c.currentInstructions())
+	c.loadSymbol(iter)
+	iterNext := c.emit(code.OpIterNext, withIndex, 9999)
+
+	// each iteration gets fresh bindings, like the evaluator's per
+	// iteration Environment
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	if node.Index != nil {
+		c.defineSymbol(c.symbolTable.Define(node.Index.Value))
+	}
+	if err := c.compileBinding(node.Variable); err != nil {
+		return err
+	}
+
+	l := c.enterLoop(node.Label, loopStart)
+	l.iter = &iter
+	if err := c.compileStatement(node.Body); err != nil {
+		return err
+	}
+	c.symbolTable = c.symbolTable.Outer
+
+	c.emit(code.OpJump, loopStart)
+	c.changeOperand(iterNext, len(c.currentInstructions()))
+	c.leaveLoop(l)
+	return nil
+}
+
+// compileBinding binds the value on top of the stack to the names in a
+// let, for or parameter pattern, like bindPattern
+func (c *Compiler) compileBinding(pattern ast.Expression) error {
+	switch p := pattern.(type) {
+	case *ast.Identifier:
+		c.defineSymbol(c.symbolTable.Define(p.Value))
+
+	case *ast.ArrayPattern:
+		c.emit(code.OpUnpackArray, len(p.Elements), restOperand(p.Rest))
+		for _, el := range p.Elements {
+			if err := c.compileBinding(el); err != nil {
+				return err
+			}
+		}
+		if p.Rest != nil {
+			c.defineSymbol(c.symbolTable.Define(p.Rest.Value))
+		}
+
+	case *ast.MapPattern:
+		keys := make([]object.Object, len(p.Keys))
+		for i, key := range p.Keys {
+			keys[i] = &object.String{Value: key}
+		}
+		c.emit(code.OpUnpackMap, c.addConstant(&object.Array{Elements: keys}), restOperand(p.Rest))
+		for _, v := range p.Values {
+			if err := c.compileBinding(v); err != nil {
+				return err
+			}
+		}
+		if p.Rest != nil {
+			c.defineSymbol(c.symbolTable.Define(p.Rest.Value))
+		}
+
+	default:
+		return fmt.Errorf("cannot bind to %s", pattern.String())
+	}
+	return nil
+}
+
+func restOperand(rest *ast.Identifier) int {
+	if rest != nil {
+		return 1
+	}
+	return 0
+}
+
+func (c *Compiler) compileWhileStatement(node *ast.WhileStatement) error {
+	loopStart := len(c.currentInstructions())
+	if err := c.Compile(node.Condition); err != nil {
+		return err
+	}
+	jumpNotTruthy := c.emit(code.OpJumpNotTruthy, 9999)
+
+	l := c.enterLoop(node.Label, loopStart)
+	if err := c.compileStatement(node.Body); err != nil {
+		return err
+	}
+
+	c.emit(code.OpJump, loopStart)
+	c.changeOperand(jumpNotTruthy, len(c.currentInstructions()))
+	c.leaveLoop(l)
+	return nil
+}
+
+func (c *Compiler) enterLoop(label string, continueTarget int) *loop {
+	scope := &c.scopes[c.scopeIndex]
+	l := &loop{label: label, continueTarget: continueTarget, tryDepth: scope.tryDepth}
+	scope.loops = append(scope.loops, l)
+	return l
+}
+
+// leaveLoop points every break in the loop at the current position
+func (c *Compiler) leaveLoop(l *loop) {
+	scope := &c.scopes[c.scopeIndex]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+	for _, b := range l.breaks {
+		c.changeOperand(b, len(c.currentInstructions()))
+	}
+}
+
+func (c *Compiler) compileLoopJump(label *ast.Identifier, isBreak bool) error {
+	word := "continue"
+	if isBreak {
+		word = "break"
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	var target *loop
+	var left []*loop // the loops the jump leaves
+	for i := len(scope.loops) - 1; i >= 0; i-- {
+		if label == nil || scope.loops[i].label == label.Value {
+			target = scope.loops[i]
+			left = scope.loops[i+1:]
+			if isBreak {
+				left = scope.loops[i:]
+			}
+			break
+		}
+	}
+
+	if target == nil {
+		// same errors the evaluator raises when the signal escapes
+		if label != nil {
+			c.emitRaise("%s %s: no enclosing loop with that label", word, label.Value)
+		} else {
+			c.emitRaise("%s outside of a loop", word)
+		}
+		return nil
+	}
+
+	for i := target.tryDepth; i < scope.tryDepth; i++ {
+		c.emit(code.OpEndTry)
+	}
+	c.stopIterators(left)
+	if isBreak {
+		target.breaks = append(target.breaks, c.emit(code.OpJump, 9999))
+	} else {
+		c.emit(code.OpJump, target.continueTarget)
+	}
+	return nil
+}
+
+// stopIterators stops the iterators of the for loops a break, continue or
+// return leaves, innermost first, like the evaluator's for loop does
+func (c *Compiler) stopIterators(loops []*loop) {
+	for i := len(loops) - 1; i >= 0; i-- {
+		if loops[i].iter != nil {
+			c.loadSymbol(*loops[i].iter)
+			c.emit(code.OpIterClose)
+		}
+	}
+}
+
+// compileImportStatement binds a module, or the names taken from it. The
+// module is loaded and run by the evaluator, whose cache the vm shares, so
+// each file is still evaluated once. With wantValue the statement leaves
+// its value, the module or null for a selective import, as
+// evalImportStatement does.
+func (c *Compiler) compileImportStatement(node *ast.ImportStatement, wantValue bool) error {
+	path := c.addConstant(&object.String{Value: node.Path})
+
+	if node.Names == nil {
+		name := strings.TrimSuffix(filepath.Base(node.Path), filepath.Ext(node.Path))
+		if node.Alias != nil {
+			name = node.Alias.Value
+		}
+		c.emit(code.OpImport, path)
+		if wantValue {
+			c.emit(code.OpDup)
+		}
+		c.defineSymbol(c.symbolTable.Define(name))
+		return nil
+	}
+
+	for _, n := range node.Names {
+		name := n.Name.Value
+		if n.Alias != nil {
+			name = n.Alias.Value
+		}
+		c.emit(code.OpImport, path)
+		c.emit(code.OpGetMember, c.addConstant(&object.String{Value: n.Name.Value}))
+		c.defineSymbol(c.symbolTable.Define(name))
+	}
+	if wantValue {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+func (c *Compiler) compileTryStatement(node *ast.TryStatement, wantValue bool) error {
+	scope := &c.scopes[c.scopeIndex]
+
+	try := c.emit(code.OpTry, 9999)
+	scope.tryDepth++
+	var err error
+	if wantValue {
+		err = c.compileBlockValue(node.Body)
+	} else {
+		err = c.compileStatement(node.Body)
+	}
+	if err != nil {
+		return err
+	}
+	scope.tryDepth--
+	c.emit(code.OpEndTry)
+	jump := c.emit(code.OpJump, 9999)
+
+	// the vm jumps here with the error value on the stack
+	c.changeOperand(try, len(c.currentInstructions()))
+	c.symbolTable = NewBlockSymbolTable(c.symbolTable)
+	if node.CatchVar != nil {
+		c.defineSymbol(c.symbolTable.Define(node.CatchVar.Value))
+	} else {
+		c.emit(code.OpPop)
+	}
+	if wantValue {
+		err = c.compileBlockValue(node.Catch)
+	} else {
+		err = c.compileStatement(node.Catch)
+	}
+	if err != nil {
+		return err
+	}
+	c.symbolTable = c.symbolTable.Outer
+
+	c.changeOperand(jump, len(c.currentInstructions()))
+	return nil
+}
+
+// compileStructStatement leaves the new struct type on the stack
+func (c *Compiler) compileStructStatement(node *ast.StructStatement) error {
+	if err := c.compileClosure(node.Init, node.Name.Value); err != nil {
+		return err
+	}
+	def := &object.StructType{Name: node.Name.Value}
+	for _, f := range node.Fields {
+		def.Fields = append(def.Fields, f.Name.Value)
+	}
+	c.emit(code.OpStruct, c.addConstant(def))
+	return nil
+}
+
+func (c *Compiler) compileFunctionLiteral(node *ast.FunctionLiteral) error {
+	// methods are added to their struct instead of binding a name
+	name := node.Name
+	if node.Receiver != nil {
+		if err := c.Compile(node.Receiver); err != nil {
+			return err
+		}
+		name = node.Receiver.Value + "." + node.Name
+	}
+
+	// named functions bind themselves before the body is compiled so
+	// they can recurse
+	var self Symbol
+	if node.Name != "" && node.Receiver == nil {
+		self = c.symbolTable.Define(node.Name)
+		if self.Cell {
+			c.emit(code.OpNull)
+			c.defineSymbol(self)
+		}
+	}
+
+	if err := c.compileClosure(node, name); err != nil {
+		return err
+	}
+
+	if node.Receiver != nil {
+		c.emit(code.OpDefineMethod, c.addConstant(&object.String{Value: node.Name}))
+	} else if node.Name != "" {
+		c.emit(code.OpDup)
+		if self.Cell {
+			c.storeSymbol(self)
+		} else {
+			c.defineSymbol(self)
+		}
+	}
+	return nil
+}
+
+// compileClosure compiles the function body and leaves the closure on
+// the stack
+func (c *Compiler) compileClosure(node *ast.FunctionLiteral, name string) error {
+	c.enterScope(capturedNames(node.Body))
+
+	params := make([]string, len(node.Parameters))
+	hasDefault := make([]bool, len(node.Parameters))
+	symbols := make([]Symbol, len(node.Parameters))
+	for i, p := range node.Parameters {
+		params[i] = p.Name.Value
+		hasDefault[i] = p.Default != nil
+		symbols[i] = c.symbolTable.Define(p.Name.Value)
+	}
+
+	// fill in defaults for parameters the caller left out
+	for i, p := range node.Parameters {
+		if p.Default == nil {
+			continue
+		}
+		skip := c.emit(code.OpDefault, symbols[i].Index, 9999)
+		if err := c.Compile(p.Default); err != nil {
+			return err
+		}
+		c.emit(code.OpDefineLocal, symbols[i].Index, 0)
+		c.changeOperand(skip, len(c.currentInstructions()))
+	}
+
+	// move captured parameters into cells
+	for _, sym := range symbols {
+		if sym.Cell {
+			c.emit(code.OpGetLocal, sym.Index)
+			c.defineSymbol(sym)
+		}
+	}
+
+	// destructure pattern parameters, like extendFunctionEnv
+	for i, p := range node.Parameters {
+		if p.Pattern == nil {
+			continue
+		}
+		c.emit(code.OpGetLocal, symbols[i].Index)
+		if err := c.compileBinding(p.Pattern); err != nil {
+			return err
+		}
+	}
+
+	if err := c.compileBlockValue(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.NumDefinitions()
+	if numLocals > 255 || len(freeSymbols) > 255 {
+		return fmt.Errorf("function %s has too many variables for the vm", name)
+	}
+	localNames := c.symbolTable.Names()
+	instructions, positions := c.leaveScope()
+
+	for _, s := range freeSymbols {
+		c.captureSymbol(s)
+	}
+
+	fn := &object.CompiledFunction{
+		Instructions: instructions,
+		NumLocals:    numLocals,
+		Name:         name,
+		Params:       params,
+		HasDefault:   hasDefault,
+		Variadic:     len(params) > 0 && node.Parameters[len(params)-1].Rest,
+		Generator:    node.Generator,
+		LocalNames:   localNames,
+		Positions:    positions,
+	}
+	c.emit(code.OpClosure, c.addConstant(fn), len(freeSymbols))
+	return nil
+}
+
+func (c *Compiler) compileCallExpression(node *ast.CallExpression) error {
+	// for recv.name(...) the receiver sits where the function would
+	me, isMethod := node.Function.(*ast.MemberExpression)
+	if isMethod {
+		if err := c.Compile(me.Object); err != nil {
+			return err
+		}
+	} else if err := c.Compile(node.Function); err != nil {
+		return err
+	}
+
+	named := false
+	names := make([]object.Object, len(node.Arguments))
+	for i, a := range node.Arguments {
+		if err := c.Compile(a.Value); err != nil {
+			return err
+		}
+		names[i] = &object.String{Value: a.Key()}
+		if a.Key() != "" {
+			named = true
+		}
+	}
+
+	if isMethod {
+		// a local or enclosing function's variable of the method's name is
+		// passed along, since the vm can only look up globals by name
+		local := 0
+		if sym, ok := c.symbolTable.Resolve(me.Member.Value); ok && sym.Scope != GlobalScope {
+			c.loadSymbol(sym)
+			local = 1
+		}
+		name := c.addConstant(&object.String{Value: me.Member.Value})
+		c.emit(code.OpCallMethod, name, len(node.Arguments), c.addConstant(&object.Array{Elements: names}), local)
+	} else if named {
+		c.emit(code.OpCallNamed, len(node.Arguments), c.addConstant(&object.Array{Elements: names}))
+	} else {
+		c.emit(code.OpCall, len(node.Arguments))
+	}
+	return nil
+}
+
+// compilePipeExpression turns `x |> f(a)` into f(x, a), as evalPipeExpression does
+func (c *Compiler) compilePipeExpression(node *ast.PipeExpression) error {
+	switch right := node.Right.(type) {
+	case *ast.CallExpression:
+		if err := c.Compile(right.Function); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		named := false
+		names := []object.Object{&object.String{}}
+		for _, a := range right.Arguments {
+			if err := c.Compile(a.Value); err != nil {
+				return err
+			}
+			names = append(names, &object.String{Value: a.Key()})
+			if a.Key() != "" {
+				named = true
+			}
+		}
+		// report errors at the call, like evalPipeExpression
+		c.line, c.col = right.Position()
+		if named {
+			c.emit(code.OpCallNamed, len(right.Arguments)+1, c.addConstant(&object.Array{Elements: names}))
+		} else {
+			c.emit(code.OpCall, len(right.Arguments)+1)
+		}
+
+	case *ast.Identifier:
+		if err := c.Compile(right); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		c.line, c.col = right.Position()
+		c.emit(code.OpCall, 1)
+
+	default:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+		c.emitRaise("right side of pipe must be a function call")
+	}
+	return nil
+}
+
+func (c *Compiler) compileAssignExpression(node *ast.AssignExpression) error {
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+
+	switch target := node.Name.(type) {
+	case *ast.Identifier:
+		c.emit(code.OpDup)
+		c.storeSymbol(c.resolve(target.Value))
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex)
+
+	case *ast.MemberExpression:
+		if err := c.Compile(target.Object); err != nil {
+			return err
+		}
+		c.emit(code.OpSetMember, c.addConstant(&object.String{Value: target.Member.Value}))
+
+	default:
+		c.emit(code.OpPop)
+		c.emitRaise("cannot assign to this expression")
+	}
+	return nil
+}
+
+// resolve finds name, treating unknown names as globals that may be
+// defined later or be builtins; the vm sorts that out at runtime
+func (c *Compiler) resolve(name string) Symbol {
+	if sym, ok := c.symbolTable.Resolve(name); ok {
+		return sym
+	}
+	return c.symbolTable.root().Define(name)
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	}
+}
+
+// storeSymbol assigns to an existing binding
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpSetFree, s.Index)
+	}
+}
+
+// defineSymbol creates a binding from the value on top of the stack
+func (c *Compiler) defineSymbol(s Symbol) {
+	cell := 0
+	if s.Cell {
+		cell = 1
+	}
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpDefineGlobal, s.Index, cell)
+	case LocalScope:
+		c.emit(code.OpDefineLocal, s.Index, cell)
+	}
+}
+
+// captureSymbol pushes the raw slot contents (the cell) for a closure
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpCaptureGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	}
+}
+
+func (c *Compiler) hiddenName() string {
+	c.hiddenCount++
+	// $ can't appear in identifiers, so these never clash
+	return fmt.Sprintf("$%d", c.hiddenCount)
+}
+
+func (c *Compiler) emitRaise(format string, a ...interface{}) {
+	c.emit(code.OpRaise, c.addConstant(&object.String{Value: fmt.Sprintf(format, a...)}))
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	scope := &c.scopes[c.scopeIndex]
+	pos := len(scope.instructions)
+
+	n := len(scope.positions)
+	if c.line > 0 && (n == 0 || scope.positions[n-1].Line != c.line || scope.positions[n-1].Col != c.col) {
+		scope.positions = append(scope.positions, object.SourcePos{Offset: pos, Line: c.line, Col: c.col})
+	}
+
+	scope.instructions = append(scope.instructions, ins...)
+	return pos
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	ins := c.currentInstructions()
+	op := code.Opcode(ins[opPos])
+	def, _ := code.Lookup(byte(op))
+
+	// jump targets are always the last operand
+	operands, _ := code.ReadOperands(def, ins[opPos+1:])
+	operands[len(operands)-1] = operand
+	copy(ins[opPos:], code.Make(op, operands...))
+}
+
+func (c *Compiler) enterScope(captured map[string]bool) {
+	c.scopes = append(c.scopes, CompilationScope{})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable, captured)
+}
+
+func (c *Compiler) leaveScope() (code.Instructions, []object.SourcePos) {
+	scope := c.scopes[c.scopeIndex]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return scope.instructions, scope.positions
+}
@@ -0,0 +1,122 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope SymbolScope = "GLOBAL"
+	LocalScope  SymbolScope = "LOCAL"
+	FreeScope   SymbolScope = "FREE"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	Cell  bool // captured by a closure, so the slot holds a shared cell
+}
+
+// SymbolTable resolves names for one function, or for one block inside a
+// function. Block tables (for loop bodies, catch blocks, match arms) share
+// their function's slot counter so every name in a function gets its own
+// local slot, the same way the evaluator gives each block its own
+// Environment.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store  map[string]Symbol
+	names  *[]string // slot names, shared by a function and its blocks
+	block  bool
+	global bool
+
+	// names referenced from closures nested in this function, these get
+	// cells so assignments are seen by every closure
+	captured map[string]bool
+
+	FreeSymbols []Symbol
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol), names: &[]string{}, global: true}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable, captured map[string]bool) *SymbolTable {
+	return &SymbolTable{Outer: outer, store: make(map[string]Symbol), names: &[]string{}, captured: captured}
+}
+
+func NewBlockSymbolTable(outer *SymbolTable) *SymbolTable {
+	return &SymbolTable{
+		Outer:    outer,
+		store:    make(map[string]Symbol),
+		names:    outer.names,
+		block:    true,
+		global:   outer.global,
+		captured: outer.captured,
+	}
+}
+
+// NumDefinitions is the number of slots the function needs
+func (s *SymbolTable) NumDefinitions() int {
+	return len(*s.names)
+}
+
+// Names returns the name defined in each slot, for error messages
+func (s *SymbolTable) Names() []string {
+	return *s.names
+}
+
+// Define binds name in this table, reusing the slot if it is already here
+func (s *SymbolTable) Define(name string) Symbol {
+	if sym, ok := s.store[name]; ok {
+		return sym
+	}
+
+	symbol := Symbol{Name: name, Index: len(*s.names)}
+	if s.global {
+		symbol.Scope = GlobalScope
+		// top level names are shared by every closure already, only
+		// names in blocks need cells
+		symbol.Cell = s.block && s.captured[name]
+	} else {
+		symbol.Scope = LocalScope
+		symbol.Cell = s.captured[name]
+	}
+
+	s.store[name] = symbol
+	*s.names = append(*s.names, name)
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1, Scope: FreeScope, Cell: original.Cell}
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	if sym, ok := s.store[name]; ok {
+		return sym, ok
+	}
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+
+	sym, ok := s.Outer.Resolve(name)
+	if !ok || s.block {
+		return sym, ok
+	}
+
+	// crossing a function boundary
+	if sym.Scope == GlobalScope && !sym.Cell {
+		return sym, ok
+	}
+	return s.defineFree(sym), true
+}
+
+func (s *SymbolTable) root() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
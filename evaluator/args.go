@@ -0,0 +1,121 @@
+package evaluator
+
+import (
+	"pearl/ast"
+	"pearl/object"
+)
+
+// argNames lists how each argument of a call is passed, see ast.CallArg.Key
+func argNames(args []ast.CallArg) []string {
+	names := make([]string, len(args))
+	for i, a := range args {
+		names[i] = a.Key()
+	}
+	return names
+}
+
+// spreadArgs expands ...arr into positional arguments and **opts into
+// named ones
+func spreadArgs(args []object.Object, names []string) ([]object.Object, []string, *object.Error) {
+	spread := false
+	for _, name := range names {
+		if name == "..." || name == "**" {
+			spread = true
+		}
+	}
+	if !spread {
+		return args, names, nil
+	}
+
+	var outArgs []object.Object
+	var outNames []string
+	for i, arg := range args {
+		switch names[i] {
+		case "...":
+			arr, ok := arg.(*object.Array)
+			if !ok {
+				return nil, nil, newKindError(object.TYPE_ERROR, "cannot spread %s with ..., it needs an array", typeName(arg))
+			}
+			for _, el := range arr.Elements {
+				outArgs = append(outArgs, el)
+				outNames = append(outNames, "")
+			}
+		case "**":
+			m, ok := arg.(*object.Map)
+			if !ok {
+				return nil, nil, newKindError(object.TYPE_ERROR, "cannot spread %s with **, it needs a map", typeName(arg))
+			}
+			for _, pair := range m.Ordered() {
+				key, ok := pair.Key.(*object.String)
+				if !ok {
+					return nil, nil, newKindError(object.TYPE_ERROR, "cannot spread a map with %s keys as named arguments", pair.Key.Type())
+				}
+				outArgs = append(outArgs, pair.Value)
+				outNames = append(outNames, key.Value)
+			}
+		default:
+			outArgs = append(outArgs, arg)
+			outNames = append(outNames, names[i])
+		}
+	}
+	return outArgs, outNames, nil
+}
+
+// bindArgs matches arguments to parameters the same way for every user
+// function: positional arguments fill the parameters from the left, and
+// named arguments go to the parameter with that name, which mustn't have a
+// value already. Extra positional arguments are collected into an array
+// when the last parameter is a rest parameter. The result has one value
+// per parameter, nil where the caller left it out, so the default (or
+// null) applies.
+func bindArgs(fnName string, params []string, variadic bool, args []object.Object, names []string) ([]object.Object, *object.Error) {
+	if fnName == "" {
+		fnName = "<anonymous fn>"
+	}
+	bound := make([]object.Object, len(params))
+	fixed := len(params)
+	if variadic {
+		fixed--
+	}
+
+	var positional []object.Object
+	for i, arg := range args {
+		if i >= len(names) || names[i] == "" {
+			positional = append(positional, arg)
+		}
+	}
+	n := min(len(positional), fixed)
+	copy(bound, positional[:n])
+	if variadic {
+		bound[fixed] = &object.Array{Elements: append([]object.Object{}, positional[n:]...)}
+	} else if len(positional) > fixed {
+		return nil, newKindError(object.TYPE_ERROR, "%s() takes %d %s, got %d", fnName, len(params), plural(len(params), "argument"), len(args))
+	}
+
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		idx := -1
+		for j, p := range params[:fixed] {
+			if p == name {
+				idx = j
+			}
+		}
+		if idx < 0 {
+			return nil, newKindError(object.TYPE_ERROR, "%s() got an unknown argument %s", fnName, name)
+		}
+		if bound[idx] != nil {
+			return nil, newKindError(object.TYPE_ERROR, "%s() got more than one value for %s", fnName, name)
+		}
+		bound[idx] = args[i]
+	}
+	return bound, nil
+}
+
+func plural(n int, word string) string {
+	if n == 1 {
+		return word
+	}
+	return word + "s"
+}
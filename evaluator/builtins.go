@@ -1,19 +1,138 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
-	"pearl/ast"
+	"io"
+	"io/fs"
+	"os"
 	"pearl/object"
-	"regexp"
-	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
-// EvalFn is set by init() to break the cycle
-var EvalFn func(node ast.Node, env *object.Environment) object.Object
+// applyFn is set by init() to break the cycle
+var applyFn func(fn object.Object, args []object.Object, names []string) object.Object
 
 func init() {
-	EvalFn = Eval
+	applyFn = applyFunction
+}
+
+// isCallable reports whether obj can be called like a function
+func isCallable(obj object.Object) bool {
+	switch obj.(type) {
+	case *object.Function, *object.Closure, *object.Builtin, *object.StructType:
+		return true
+	}
+	return false
+}
+
+// callCallback calls a function passed to a builtin like map or filter,
+// the same way a call expression would. The extra arguments, like the
+// index map and filter pass, only go to parameters that are there for
+// them: ones without a default that don't collect the rest. A builtin
+// gets just args.
+func callCallback(fn object.Object, args []object.Object, extra ...object.Object) object.Object {
+	if _, ok := fn.(*object.Builtin); !ok {
+		if n := requiredParams(fn) - len(args); n > 0 {
+			args = append(append([]object.Object{}, args...), extra[:min(n, len(extra))]...)
+		}
+		if n, variadic := paramCount(fn); !variadic && len(args) > n {
+			args = args[:n]
+		}
+	}
+	return applyFn(fn, args, nil)
+}
+
+// paramCount is the number of parameters a user function or struct
+// constructor takes, and whether the last collects any extra arguments
+func paramCount(fn object.Object) (int, bool) {
+	switch fn := fn.(type) {
+	case *object.Function:
+		n := len(fn.Parameters)
+		return n, n > 0 && fn.Parameters[n-1].Rest
+	case *object.Closure:
+		return len(fn.Fn.Params), fn.Fn.Variadic
+	case *object.StructType:
+		return paramCount(fn.Init)
+	}
+	return 0, true
+}
+
+// requiredParams is the number of parameters a user function or struct
+// constructor takes before the first with a default or a ...rest one
+func requiredParams(fn object.Object) int {
+	switch fn := fn.(type) {
+	case *object.Function:
+		for i, p := range fn.Parameters {
+			if p.Default != nil || p.Rest {
+				return i
+			}
+		}
+		return len(fn.Parameters)
+	case *object.Closure:
+		for i := range fn.Fn.Params {
+			if fn.Fn.HasDefault[i] || fn.Fn.Variadic && i == len(fn.Fn.Params)-1 {
+				return i
+			}
+		}
+		return len(fn.Fn.Params)
+	case *object.StructType:
+		return requiredParams(fn.Init)
+	}
+	return 0
+}
+
+// bindBuiltinArgs moves named arguments to the positions b.Params gives
+// them. Parameters skipped over are passed as null, which builtins treat
+// as "use the default".
+func bindBuiltinArgs(b *object.Builtin, args []object.Object, names []string) ([]object.Object, *object.Error) {
+	named := false
+	for _, name := range names {
+		if name != "" {
+			named = true
+		}
+	}
+	if !named || b.Params == nil {
+		return args, nil
+	}
+
+	bound := []object.Object{}
+	for i, arg := range args {
+		if i >= len(names) || names[i] == "" {
+			bound = append(bound, arg)
+		}
+	}
+	positional := len(bound)
+
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		idx := -1
+		for j, p := range b.Params {
+			if p == name {
+				idx = j
+			}
+		}
+		if idx < 0 {
+			return nil, newKindError(object.TYPE_ERROR, "%s() got an unexpected argument %s", b.Name, name)
+		}
+		for len(bound) <= idx {
+			bound = append(bound, nil)
+		}
+		if idx < positional || bound[idx] != nil {
+			return nil, newKindError(object.TYPE_ERROR, "%s() got more than one value for %s", b.Name, name)
+		}
+		bound[idx] = args[i]
+	}
+
+	for i := range bound {
+		if bound[i] == nil {
+			bound[i] = NULL
+		}
+	}
+	return bound, nil
 }
 
 // helper functions for builtins
@@ -49,9 +168,9 @@ var builtins = map[string]*object.Builtin{
 		Name: "print",
 		Fn: func(args ...object.Object) object.Object {
 			for _, arg := range args {
-				fmt.Print(arg.Inspect())
+				fmt.Fprint(Stdout, arg.Inspect())
 			}
-			fmt.Println()
+			fmt.Fprintln(Stdout)
 			return NULL
 		},
 	},
@@ -62,23 +181,37 @@ var builtins = map[string]*object.Builtin{
 			if len(args) != 1 {
 				return newError("type() takes 1 argument, got %d", len(args))
 			}
-			return &object.String{Value: string(args[0].Type())}
+			return &object.String{Value: typeName(args[0])}
 		},
 	},
 
 	"len": {
-		Name: "len",
+		Name:   "len",
+		Params: []string{"value", "graphemes"},
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
+			if len(args) < 1 || len(args) > 2 {
 				return newError("len() takes 1 argument, got %d", len(args))
 			}
 			switch arg := args[0].(type) {
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				clusters, err := graphemesArg("len", args, 1)
+				if err != nil {
+					return err
+				}
+				if clusters {
+					return &object.Integer{Value: int64(len(graphemes(arg.Value)))}
+				}
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 			case *object.Array:
 				return &object.Integer{Value: int64(len(arg.Elements))}
 			case *object.Map:
-				return &object.Integer{Value: int64(len(arg.Pairs))}
+				return &object.Integer{Value: int64(arg.Len())}
+			case *object.Range:
+				n, ok := arg.Len()
+				if !ok {
+					return newError("len() of %s is more than an integer holds", arg.Inspect())
+				}
+				return &object.Integer{Value: n}
 			default:
 				return newError("len() not supported for %s", args[0].Type())
 			}
@@ -259,6 +392,28 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 
+	"sub": {
+		Name: "sub",
+		Fn: func(args ...object.Object) object.Object {
+			s, re, err := substituteArgs("sub", args)
+			if err != nil {
+				return err
+			}
+			return substitute("sub()", s, re, args[2], false)
+		},
+	},
+
+	"gsub": {
+		Name: "gsub",
+		Fn: func(args ...object.Object) object.Object {
+			s, re, err := substituteArgs("gsub", args)
+			if err != nil {
+				return err
+			}
+			return substitute("gsub()", s, re, args[2], true)
+		},
+	},
+
 	"contains": {
 		Name: "contains",
 		Fn: func(args ...object.Object) object.Object {
@@ -279,8 +434,10 @@ var builtins = map[string]*object.Builtin{
 					}
 				}
 				return FALSE
+			case *object.Range:
+				return nativeBoolToBooleanObject(container.Contains(args[1]))
 			default:
-				return newError("contains() requires string or array")
+				return newError("contains() requires string, array or range")
 			}
 		},
 	},
@@ -322,9 +479,10 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"substr": {
-		Name: "substr",
+		Name:   "substr",
+		Params: []string{"s", "start", "len", "graphemes"},
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) < 2 || len(args) > 3 {
+			if len(args) < 2 || len(args) > 4 {
 				return newError("substr() takes 2-3 arguments")
 			}
 			s, ok := args[0].(*object.String)
@@ -335,28 +493,36 @@ var builtins = map[string]*object.Builtin{
 			if !ok {
 				return newError("substr() start must be an integer")
 			}
+			clusters, err := graphemesArg("substr", args, 3)
+			if err != nil {
+				return err
+			}
+			units := textUnits(s.Value, clusters)
 			startIdx := int(start.Value)
 			if startIdx < 0 {
-				startIdx = len(s.Value) + startIdx
+				startIdx = len(units) + startIdx
 			}
 			if startIdx < 0 {
 				startIdx = 0
 			}
-			if startIdx >= len(s.Value) {
+			if startIdx >= len(units) {
 				return &object.String{Value: ""}
 			}
-			if len(args) == 2 {
-				return &object.String{Value: s.Value[startIdx:]}
-			}
-			length, ok := args[2].(*object.Integer)
-			if !ok {
-				return newError("substr() length must be an integer")
-			}
-			endIdx := startIdx + int(length.Value)
-			if endIdx > len(s.Value) {
-				endIdx = len(s.Value)
+			endIdx := len(units)
+			if len(args) > 2 && args[2] != NULL {
+				length, ok := args[2].(*object.Integer)
+				if !ok {
+					return newError("substr() length must be an integer")
+				}
+				endIdx = startIdx + int(length.Value)
+				if endIdx > len(units) {
+					endIdx = len(units)
+				}
+				if endIdx < startIdx {
+					endIdx = startIdx
+				}
 			}
-			return &object.String{Value: s.Value[startIdx:endIdx]}
+			return &object.String{Value: strings.Join(units[startIdx:endIdx], "")}
 		},
 	},
 
@@ -379,26 +545,33 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"reverse": {
-		Name: "reverse",
+		Name:   "reverse",
+		Params: []string{"value", "graphemes"},
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
+			if len(args) < 1 || len(args) > 2 {
 				return newError("reverse() takes 1 argument")
 			}
 			switch arg := args[0].(type) {
 			case *object.String:
-				runes := []rune(arg.Value)
-				for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
-					runes[i], runes[j] = runes[j], runes[i]
+				clusters, err := graphemesArg("reverse", args, 1)
+				if err != nil {
+					return err
+				}
+				units := textUnits(arg.Value, clusters)
+				for i, j := 0, len(units)-1; i < j; i, j = i+1, j-1 {
+					units[i], units[j] = units[j], units[i]
 				}
-				return &object.String{Value: string(runes)}
+				return &object.String{Value: strings.Join(units, "")}
 			case *object.Array:
 				newElements := make([]object.Object, len(arg.Elements))
 				for i, j := 0, len(arg.Elements)-1; j >= 0; i, j = i+1, j-1 {
 					newElements[i] = arg.Elements[j]
 				}
 				return &object.Array{Elements: newElements}
+			case *object.Range:
+				return arg.Reverse()
 			default:
-				return newError("reverse() requires string or array")
+				return newError("reverse() requires string, array or range")
 			}
 		},
 	},
@@ -441,6 +614,25 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 
+	"graphemes": {
+		Name: "graphemes",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("graphemes() takes 1 argument")
+			}
+			s, ok := args[0].(*object.String)
+			if !ok {
+				return newError("graphemes() requires a string")
+			}
+			clusters := graphemes(s.Value)
+			elements := make([]object.Object, len(clusters))
+			for i, c := range clusters {
+				elements[i] = &object.String{Value: c}
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+
 	"match": {
 		Name: "match",
 		Fn: func(args ...object.Object) object.Object {
@@ -459,11 +651,7 @@ var builtins = map[string]*object.Builtin{
 			if matches == nil {
 				return NULL
 			}
-			elements := make([]object.Object, len(matches))
-			for i, m := range matches {
-				elements[i] = &object.String{Value: m}
-			}
-			return &object.Array{Elements: elements}
+			return matchResult(re.Regexp, matches)
 		},
 	},
 
@@ -484,31 +672,36 @@ var builtins = map[string]*object.Builtin{
 			allMatches := re.Regexp.FindAllStringSubmatch(s.Value, -1)
 			results := make([]object.Object, len(allMatches))
 			for i, matches := range allMatches {
-				elements := make([]object.Object, len(matches))
-				for j, m := range matches {
-					elements[j] = &object.String{Value: m}
-				}
-				results[i] = &object.Array{Elements: elements}
+				results[i] = matchResult(re.Regexp, matches)
 			}
 			return &object.Array{Elements: results}
 		},
 	},
 
 	"regex": {
-		Name: "regex",
+		Name:   "regex",
+		Params: []string{"pattern", "flags"},
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("regex() takes 1 argument")
+			if len(args) < 1 || len(args) > 2 {
+				return newError("regex() takes 1-2 arguments")
 			}
 			s, ok := args[0].(*object.String)
 			if !ok {
 				return newError("regex() requires a string pattern")
 			}
-			re, err := regexp.Compile(s.Value)
+			flags := ""
+			if len(args) == 2 && args[1] != NULL {
+				f, ok := args[1].(*object.String)
+				if !ok || strings.Trim(f.Value, "ims") != "" {
+					return newError("regex() flags must be a string of i, m and s")
+				}
+				flags = f.Value
+			}
+			re, err := object.NewRegex(s.Value, flags)
 			if err != nil {
 				return newError("invalid regex: %s", err)
 			}
-			return &object.Regex{Pattern: s.Value, Regexp: re}
+			return re
 		},
 	},
 
@@ -623,21 +816,56 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"sort": {
-		Name: "sort",
+		Name:   "sort",
+		Params: []string{"values", "by", "cmp", "reverse"},
 		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("sort() takes 1 argument")
+			if len(args) < 1 || len(args) > 4 {
+				return newError("sort() takes 1-4 arguments: values, by, cmp, reverse")
 			}
-			arr, ok := args[0].(*object.Array)
-			if !ok {
-				return newError("sort() requires an array")
+			for len(args) < 4 {
+				args = append(args, NULL)
+			}
+			by, cmp, reverse := args[1], args[2], args[3]
+			if by != NULL && !isCallable(by) {
+				return newError("sort() by must be a function")
+			}
+			if cmp != NULL && !isCallable(cmp) {
+				return newError("sort() cmp must be a function")
+			}
+			if by != NULL && cmp != NULL {
+				return newError("sort() takes by or cmp, not both")
+			}
+			if reverse != NULL && reverse.Type() != object.BOOLEAN_OBJ {
+				return newError("sort() reverse must be true or false")
 			}
-			newElements := make([]object.Object, len(arr.Elements))
-			copy(newElements, arr.Elements)
-			sort.Slice(newElements, func(i, j int) bool {
-				return newElements[i].Inspect() < newElements[j].Inspect()
-			})
-			return &object.Array{Elements: newElements}
+			return sorted("sort", args[0], by, cmp, reverse == TRUE)
+		},
+	},
+
+	"sort_by": {
+		Name: "sort_by",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("sort_by() takes 2 arguments: values, fn")
+			}
+			if !isCallable(args[1]) {
+				return newError("sort_by() second arg must be a function")
+			}
+			return sorted("sort_by", args[0], args[1], NULL, false)
+		},
+	},
+
+	"min_by": {
+		Name: "min_by",
+		Fn: func(args ...object.Object) object.Object {
+			return extremeByArgs("min_by", args, false)
+		},
+	},
+
+	"max_by": {
+		Name: "max_by",
+		Fn: func(args ...object.Object) object.Object {
+			return extremeByArgs("max_by", args, true)
 		},
 	},
 
@@ -696,28 +924,25 @@ var builtins = map[string]*object.Builtin{
 			if len(args) != 2 {
 				return newError("map() takes 2 arguments: array, function")
 			}
-			arr, ok := args[0].(*object.Array)
-			if !ok {
-				return newError("map() first arg must be an array")
+			fn := args[1]
+			if !isCallable(fn) {
+				return newError("map() second arg must be a function")
 			}
-			fn, ok := args[1].(*object.Function)
+			arr, ok := args[0].(*object.Array)
 			if !ok {
-				return newError("map() second arg must be a function")
+				next, err := iterArg("map", args[0])
+				if err != nil {
+					return err
+				}
+				return lazyMap(next, stopper(args[0]), fn)
 			}
 			results := make([]object.Object, len(arr.Elements))
 			for i, el := range arr.Elements {
-				env := object.NewEnclosedEnvironment(fn.Env)
-				if len(fn.Parameters) > 0 {
-					env.Set(fn.Parameters[0].Name.Value, el)
-				}
-				if len(fn.Parameters) > 1 {
-					env.Set(fn.Parameters[1].Name.Value, &object.Integer{Value: int64(i)})
-				}
-				result := EvalFn(fn.Body, env)
+				result := callCallback(fn, []object.Object{el}, &object.Integer{Value: int64(i)})
 				if result != nil && result.Type() == object.ERROR_OBJ {
 					return result
 				}
-				results[i] = unwrapReturn(result)
+				results[i] = result
 			}
 			return &object.Array{Elements: results}
 		},
@@ -729,28 +954,25 @@ var builtins = map[string]*object.Builtin{
 			if len(args) != 2 {
 				return newError("filter() takes 2 arguments")
 			}
-			arr, ok := args[0].(*object.Array)
-			if !ok {
-				return newError("filter() first arg must be an array")
+			fn := args[1]
+			if !isCallable(fn) {
+				return newError("filter() second arg must be a function")
 			}
-			fn, ok := args[1].(*object.Function)
+			arr, ok := args[0].(*object.Array)
 			if !ok {
-				return newError("filter() second arg must be a function")
+				next, err := iterArg("filter", args[0])
+				if err != nil {
+					return err
+				}
+				return lazyFilter(next, stopper(args[0]), fn)
 			}
 			var results []object.Object
 			for i, el := range arr.Elements {
-				env := object.NewEnclosedEnvironment(fn.Env)
-				if len(fn.Parameters) > 0 {
-					env.Set(fn.Parameters[0].Name.Value, el)
-				}
-				if len(fn.Parameters) > 1 {
-					env.Set(fn.Parameters[1].Name.Value, &object.Integer{Value: int64(i)})
-				}
-				result := EvalFn(fn.Body, env)
+				result := callCallback(fn, []object.Object{el}, &object.Integer{Value: int64(i)})
 				if result != nil && result.Type() == object.ERROR_OBJ {
 					return result
 				}
-				if isTruthyBuiltin(unwrapReturn(result)) {
+				if isTruthyBuiltin(result) {
 					results = append(results, el)
 				}
 			}
@@ -764,30 +986,113 @@ var builtins = map[string]*object.Builtin{
 			if len(args) != 3 {
 				return newError("reduce() takes 3 arguments: array, function, initial")
 			}
-			arr, ok := args[0].(*object.Array)
-			if !ok {
-				return newError("reduce() first arg must be an array")
+			next, err := iterArg("reduce", args[0])
+			if err != nil {
+				return err
 			}
-			fn, ok := args[1].(*object.Function)
-			if !ok {
+			fn := args[1]
+			if !isCallable(fn) {
 				return newError("reduce() second arg must be a function")
 			}
 			acc := args[2]
-			for _, el := range arr.Elements {
-				env := object.NewEnclosedEnvironment(fn.Env)
-				if len(fn.Parameters) > 0 {
-					env.Set(fn.Parameters[0].Name.Value, acc)
+			for {
+				el, ok := next()
+				if !ok {
+					return acc
 				}
-				if len(fn.Parameters) > 1 {
-					env.Set(fn.Parameters[1].Name.Value, el)
+				if isError(el) {
+					return el
 				}
-				result := EvalFn(fn.Body, env)
+				result := callCallback(fn, []object.Object{acc, el})
 				if result != nil && result.Type() == object.ERROR_OBJ {
 					return result
 				}
-				acc = unwrapReturn(result)
+				acc = result
+			}
+		},
+	},
+
+	"take": {
+		Name: "take",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("take() takes 2 arguments: iterable, count")
 			}
-			return acc
+			n, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("take() count must be an integer")
+			}
+			if arr, ok := args[0].(*object.Array); ok {
+				end := min(max(n.Value, 0), int64(len(arr.Elements)))
+				return &object.Array{Elements: append([]object.Object{}, arr.Elements[:end]...)}
+			}
+			next, err := iterArg("take", args[0])
+			if err != nil {
+				return err
+			}
+			return lazyTake(next, stopper(args[0]), n.Value)
+		},
+	},
+
+	"skip": {
+		Name: "skip",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("skip() takes 2 arguments: iterable, count")
+			}
+			n, ok := args[1].(*object.Integer)
+			if !ok {
+				return newError("skip() count must be an integer")
+			}
+			if arr, ok := args[0].(*object.Array); ok {
+				start := min(max(n.Value, 0), int64(len(arr.Elements)))
+				return &object.Array{Elements: append([]object.Object{}, arr.Elements[start:]...)}
+			}
+			next, err := iterArg("skip", args[0])
+			if err != nil {
+				return err
+			}
+			return lazySkip(next, stopper(args[0]), n.Value)
+		},
+	},
+
+	"zip": {
+		Name: "zip",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 2 {
+				return newError("zip() takes at least 2 arguments")
+			}
+			lazy := false
+			sources := make([]func() (object.Object, bool), len(args))
+			stops := make([]func(), len(args))
+			for i, arg := range args {
+				if _, ok := arg.(*object.Array); !ok {
+					lazy = true
+				}
+				next, err := iterArg("zip", arg)
+				if err != nil {
+					return err
+				}
+				sources[i], stops[i] = next, stopper(arg)
+			}
+			if lazy {
+				return lazyZip(sources, stops)
+			}
+			return collect(lazyZip(sources, stops).Next)
+		},
+	},
+
+	"collect": {
+		Name: "collect",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("collect() takes 1 argument")
+			}
+			next, err := iterArg("collect", args[0])
+			if err != nil {
+				return err
+			}
+			return collect(next)
 		},
 	},
 
@@ -801,8 +1106,8 @@ var builtins = map[string]*object.Builtin{
 			if !ok {
 				return newError("keys() requires a map")
 			}
-			var keys []object.Object
-			for _, pair := range m.Pairs {
+			keys := []object.Object{}
+			for _, pair := range m.Ordered() {
 				keys = append(keys, pair.Key)
 			}
 			return &object.Array{Elements: keys}
@@ -819,14 +1124,59 @@ var builtins = map[string]*object.Builtin{
 			if !ok {
 				return newError("values() requires a map")
 			}
-			var values []object.Object
-			for _, pair := range m.Pairs {
+			values := []object.Object{}
+			for _, pair := range m.Ordered() {
 				values = append(values, pair.Value)
 			}
 			return &object.Array{Elements: values}
 		},
 	},
 
+	"items": {
+		Name: "items",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("items() takes 1 argument")
+			}
+			var pairs []object.MapPair
+			switch m := args[0].(type) {
+			case *object.Map:
+				pairs = m.Ordered()
+			case *object.Struct:
+				pairs = m.Pairs()
+			default:
+				return newError("items() requires a map")
+			}
+			items := []object.Object{}
+			for _, pair := range pairs {
+				items = append(items, &object.Array{Elements: []object.Object{pair.Key, pair.Value}})
+			}
+			return &object.Array{Elements: items}
+		},
+	},
+
+	"delete": {
+		Name: "delete",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("delete() takes 2 arguments")
+			}
+			m, ok := args[0].(*object.Map)
+			if !ok {
+				return newError("delete() requires a map")
+			}
+			if _, ok := args[1].(object.Hashable); !ok {
+				return newError("unusable as map key: %s", args[1].Type())
+			}
+			val, ok := m.Get(args[1])
+			if !ok {
+				return NULL
+			}
+			m.Delete(args[1])
+			return val
+		},
+	},
+
 	"int": {
 		Name: "int",
 		Fn: func(args ...object.Object) object.Object {
@@ -890,6 +1240,61 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 
+	"format": {
+		Name: "format",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError("format() takes a format string and the values to fill in")
+			}
+			format, ok := args[0].(*object.String)
+			if !ok {
+				return newError("format() requires a format string")
+			}
+			out, err := formatString(format.Value, args[1:])
+			if err != nil {
+				return err
+			}
+			return &object.String{Value: out}
+		},
+	},
+
+	"sprintf": {
+		Name: "sprintf",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError("sprintf() takes a format string and the values to fill in")
+			}
+			format, ok := args[0].(*object.String)
+			if !ok {
+				return newError("sprintf() requires a format string")
+			}
+			out, err := sprintf("sprintf", format.Value, args[1:])
+			if err != nil {
+				return err
+			}
+			return &object.String{Value: out}
+		},
+	},
+
+	"printf": {
+		Name: "printf",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError("printf() takes a format string and the values to fill in")
+			}
+			format, ok := args[0].(*object.String)
+			if !ok {
+				return newError("printf() requires a format string")
+			}
+			out, err := sprintf("printf", format.Value, args[1:])
+			if err != nil {
+				return err
+			}
+			fmt.Fprint(Stdout, out)
+			return NULL
+		},
+	},
+
 	"find": {
 		Name: "find",
 		Fn: func(args ...object.Object) object.Object {
@@ -902,7 +1307,7 @@ var builtins = map[string]*object.Builtin{
 				if !ok {
 					return newError("find() needle must be a string")
 				}
-				idx := strings.Index(container.Value, needle.Value)
+				idx := runeIndex(container.Value, strings.Index(container.Value, needle.Value))
 				return &object.Integer{Value: int64(idx)}
 			case *object.Array:
 				for i, el := range container.Elements {
@@ -919,31 +1324,399 @@ var builtins = map[string]*object.Builtin{
 
 	"range": {
 		Name: "range",
+		Fn: func(args ...object.Object) object.Object {
+			switch len(args) {
+			case 1:
+				return newRange(&object.Integer{Value: 0}, args[0], nil, false)
+			case 2:
+				return newRange(args[0], args[1], nil, false)
+			case 3:
+				return newRange(args[0], args[1], args[2], false)
+			default:
+				return newError("range() takes 1-3 arguments")
+			}
+		},
+	},
+
+	"read_file": {
+		Name: "read_file",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("read_file() takes 1 argument")
+			}
+			path, ok := args[0].(*object.String)
+			if !ok {
+				return newError("read_file() requires a path string")
+			}
+			data, err := os.ReadFile(path.Value)
+			if err != nil {
+				return ioError("cannot read", path.Value, err)
+			}
+			return &object.String{Value: string(data)}
+		},
+	},
+
+	"read_lines": {
+		Name: "read_lines",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("read_lines() takes 1 argument")
+			}
+			path, ok := args[0].(*object.String)
+			if !ok {
+				return newError("read_lines() requires a path string")
+			}
+			handle, err := os.Open(path.Value)
+			if err != nil {
+				return ioError("cannot read", path.Value, err)
+			}
+			f := object.NewFile(path.Value, "r", handle)
+			defer f.Close()
+
+			elements := []object.Object{}
+			for {
+				line, ok, err := f.ReadLine()
+				if err != nil {
+					return ioError("cannot read", path.Value, err)
+				}
+				if !ok {
+					break
+				}
+				elements = append(elements, &object.String{Value: line})
+			}
+			return &object.Array{Elements: elements}
+		},
+	},
+
+	"write_file": {
+		Name: "write_file",
+		Fn: func(args ...object.Object) object.Object {
+			return writeFile("write_file", os.O_TRUNC, args)
+		},
+	},
+
+	"append_file": {
+		Name: "append_file",
+		Fn: func(args ...object.Object) object.Object {
+			return writeFile("append_file", os.O_APPEND, args)
+		},
+	},
+
+	"open": {
+		Name:   "open",
+		Params: []string{"path", "mode"},
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) < 1 || len(args) > 2 {
-				return newError("range() takes 1-2 arguments")
+				return newError("open() takes 1-2 arguments")
 			}
-			var start, end int64
-			if len(args) == 1 {
-				n, ok := args[0].(*object.Integer)
+			path, ok := args[0].(*object.String)
+			if !ok {
+				return newError("open() requires a path string")
+			}
+			mode := "r"
+			if len(args) == 2 {
+				m, ok := args[1].(*object.String)
 				if !ok {
-					return newError("range() requires integers")
+					return newError("open() mode must be a string")
 				}
-				start = 0
-				end = n.Value
-			} else {
-				s, ok := args[0].(*object.Integer)
+				mode = m.Value
+			}
+
+			var handle *os.File
+			var err error
+			switch mode {
+			case "r":
+				handle, err = os.Open(path.Value)
+			case "w":
+				handle, err = os.OpenFile(path.Value, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			case "a":
+				handle, err = os.OpenFile(path.Value, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			default:
+				return newError("open() mode must be \"r\", \"w\" or \"a\", got %q", mode)
+			}
+			if err != nil {
+				return ioError("cannot open", path.Value, err)
+			}
+			return object.NewFile(path.Value, mode, handle)
+		},
+	},
+
+	"read_line": {
+		Name: "read_line",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) > 1 {
+				return newError("read_line() takes 0-1 arguments")
+			}
+			f := Stdin
+			if len(args) == 1 {
+				var ok bool
+				f, ok = args[0].(*object.File)
 				if !ok {
-					return newError("range() requires integers")
+					return newError("read_line() requires a file")
+				}
+			}
+			line, ok, err := f.ReadLine()
+			if err != nil {
+				return ioError("cannot read", f.Path, err)
+			}
+			if !ok {
+				return NULL
+			}
+			return &object.String{Value: line}
+		},
+	},
+
+	"json_parse": {
+		Name: "json_parse",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("json_parse() takes 1 argument")
+			}
+			s, ok := args[0].(*object.String)
+			if !ok {
+				return newError("json_parse() requires a string")
+			}
+			return jsonParse(s.Value)
+		},
+	},
+
+	"json_stringify": {
+		Name:   "json_stringify",
+		Params: []string{"value", "indent"},
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("json_stringify() takes 1-2 arguments")
+			}
+			indent := int64(0)
+			if len(args) == 2 {
+				n, ok := args[1].(*object.Integer)
+				if !ok || n.Value < 0 {
+					return newError("json_stringify() indent must be a non-negative integer")
 				}
-				e, ok := args[1].(*object.Integer)
+				indent = n.Value
+			}
+			return jsonStringify(args[0], int(indent))
+		},
+	},
+
+	"csv_parse": {
+		Name:   "csv_parse",
+		Params: []string{"text", "sep", "header"},
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("csv_parse() takes 1-3 arguments")
+			}
+			text, ok := args[0].(*object.String)
+			if !ok {
+				return newError("csv_parse() requires a string")
+			}
+			sep, header, err := csvOptions("csv_parse", args[1:])
+			if err != nil {
+				return err
+			}
+			return csvParse(text.Value, sep, header)
+		},
+	},
+
+	"csv_reader": {
+		Name:   "csv_reader",
+		Params: []string{"file", "sep", "header"},
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("csv_reader() takes 1-3 arguments")
+			}
+			f, ok := iterableOf(args[0]).(*object.File)
+			if !ok {
+				return newError("csv_reader() requires a file")
+			}
+			sep, header, err := csvOptions("csv_reader", args[1:])
+			if err != nil {
+				return err
+			}
+			return csvReader(f, sep, header)
+		},
+	},
+
+	"csv_stringify": {
+		Name:   "csv_stringify",
+		Params: []string{"rows", "sep"},
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("csv_stringify() takes 1-2 arguments")
+			}
+			rows, ok := args[0].(*object.Array)
+			if !ok {
+				return newError("csv_stringify() requires an array of rows")
+			}
+			sep, _, err := csvOptions("csv_stringify", args[1:])
+			if err != nil {
+				return err
+			}
+			return csvStringify(rows, sep)
+		},
+	},
+
+	"stdin": {
+		Name: "stdin",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 0 {
+				return newError("stdin() takes no arguments")
+			}
+			text, err := Stdin.ReadAll()
+			if err != nil {
+				return ioError("cannot read", Stdin.Path, err)
+			}
+			return &object.String{Value: text}
+		},
+	},
+
+	"env": {
+		Name:   "env",
+		Params: []string{"name", "default"},
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 2 {
+				return newError("env() takes 1-2 arguments")
+			}
+			name, ok := args[0].(*object.String)
+			if !ok {
+				return newError("env() requires a variable name")
+			}
+			if val, ok := os.LookupEnv(name.Value); ok {
+				return &object.String{Value: val}
+			}
+			if len(args) == 2 {
+				return args[1]
+			}
+			return NULL
+		},
+	},
+
+	"set_env": {
+		Name: "set_env",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 2 {
+				return newError("set_env() takes 2 arguments")
+			}
+			name, ok := args[0].(*object.String)
+			if !ok {
+				return newError("set_env() requires a variable name")
+			}
+			if err := os.Setenv(name.Value, textOf(args[1])); err != nil {
+				return newError("set_env(): %s", err)
+			}
+			return NULL
+		},
+	},
+
+	"exit": {
+		Name: "exit",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) > 1 {
+				return newError("exit() takes 0-1 arguments")
+			}
+			code := int64(0)
+			if len(args) == 1 {
+				n, ok := args[0].(*object.Integer)
 				if !ok {
-					return newError("range() requires integers")
+					return newError("exit() requires an integer status")
 				}
-				start = s.Value
-				end = e.Value
+				code = n.Value
 			}
-			return &object.Range{Start: start, End: end}
+			return &object.Error{Kind: object.EXIT, Message: "exit", Code: int(code)}
 		},
 	},
+
+	"write": {
+		Name: "write",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 {
+				return newError("write() takes a file and the values to write")
+			}
+			f, ok := args[0].(*object.File)
+			if !ok {
+				return newError("write() requires a file")
+			}
+			for _, arg := range args[1:] {
+				if err := f.Write(textOf(arg)); err != nil {
+					return ioError("cannot write", f.Path, err)
+				}
+			}
+			return NULL
+		},
+	},
+
+	"close": {
+		Name: "close",
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("close() takes 1 argument")
+			}
+			f, ok := args[0].(*object.File)
+			if !ok {
+				return newError("close() requires a file")
+			}
+			if err := f.Close(); err != nil {
+				return ioError("cannot close", f.Path, err)
+			}
+			return NULL
+		},
+	},
+}
+
+// Stdin is standard input. Everything that reads it shares this handle
+// so lines aren't lost to separate buffers.
+var Stdin = object.NewFile("<stdin>", "r", os.Stdin)
+
+// Stdout is where print and printf write
+var Stdout io.Writer = os.Stdout
+
+// iterableOf lets `for line in stdin` name the builtin and still stream
+// standard input
+func iterableOf(obj object.Object) object.Object {
+	if b, ok := obj.(*object.Builtin); ok && b.Name == "stdin" {
+		return Stdin
+	}
+	return obj
+}
+
+// writeFile backs write_file and append_file
+func writeFile(name string, flag int, args []object.Object) object.Object {
+	if len(args) != 2 {
+		return newError("%s() takes 2 arguments", name)
+	}
+	path, ok := args[0].(*object.String)
+	if !ok {
+		return newError("%s() requires a path string", name)
+	}
+	handle, err := os.OpenFile(path.Value, os.O_WRONLY|os.O_CREATE|flag, 0644)
+	if err != nil {
+		return ioError("cannot write", path.Value, err)
+	}
+	_, err = handle.WriteString(textOf(args[1]))
+	if closeErr := handle.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return ioError("cannot write", path.Value, err)
+	}
+	return NULL
+}
+
+// ioError reports a filesystem failure as an IOError, without the
+// operation and path Go's errors repeat
+func ioError(what, path string, err error) *object.Error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return newKindError(object.IO_ERROR, "%s %s: %s", what, path, err)
+}
+
+// textOf is the text written out for a value: strings as they are,
+// anything else the way print shows it
+func textOf(obj object.Object) string {
+	if s, ok := obj.(*object.String); ok {
+		return s.Value
+	}
+	return obj.Inspect()
 }
@@ -0,0 +1,173 @@
+package evaluator
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"pearl/object"
+	"strings"
+	"unicode/utf8"
+)
+
+// csvOptions reads the optional sep and header arguments shared by
+// csv_parse and csv_reader; null means the default
+func csvOptions(name string, args []object.Object) (rune, bool, *object.Error) {
+	sep, header := ',', true
+
+	if len(args) > 0 && args[0] != NULL {
+		s, ok := args[0].(*object.String)
+		if !ok || utf8.RuneCountInString(s.Value) != 1 {
+			return 0, false, newError("%s() sep must be a single character", name)
+		}
+		sep, _ = utf8.DecodeRuneInString(s.Value)
+	}
+	if len(args) > 1 && args[1] != NULL {
+		b, ok := args[1].(*object.Boolean)
+		if !ok {
+			return 0, false, newError("%s() header must be true or false", name)
+		}
+		header = b.Value
+	}
+	return sep, header, nil
+}
+
+func newCSVReader(r io.Reader, sep rune) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = sep
+	return reader
+}
+
+// csvRow turns a record into a map keyed by the header, or a plain
+// array when there's no header
+func csvRow(record, header []string) object.Object {
+	if header == nil {
+		row := &object.Array{Elements: make([]object.Object, len(record))}
+		for i, field := range record {
+			row.Elements[i] = &object.String{Value: field}
+		}
+		return row
+	}
+
+	row := object.NewMap()
+	for i, key := range header {
+		row.Set(&object.String{Value: key}, &object.String{Value: record[i]})
+	}
+	return row
+}
+
+func csvError(name string, err error) *object.Error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return newError("%s(): line %d, col %d: %s", name, parseErr.Line, parseErr.Column, parseErr.Err)
+	}
+	return newError("%s(): %s", name, err)
+}
+
+func csvParse(text string, sep rune, header bool) object.Object {
+	records, err := newCSVReader(strings.NewReader(text), sep).ReadAll()
+	if err != nil {
+		return csvError("csv_parse", err)
+	}
+
+	rows := &object.Array{Elements: []object.Object{}}
+	var keys []string
+	for i, record := range records {
+		if header && i == 0 {
+			keys = record
+			continue
+		}
+		rows.Elements = append(rows.Elements, csvRow(record, keys))
+	}
+	return rows
+}
+
+// csvReader streams rows from f for a for loop
+func csvReader(f *object.File, sep rune, header bool) object.Object {
+	r, err := f.Reader()
+	if err != nil {
+		return ioError("cannot read", f.Path, err)
+	}
+	reader := newCSVReader(r, sep)
+
+	var keys []string
+	done := false
+	return &object.Iterator{Name: "csv_reader", Next: func() (object.Object, bool) {
+		for !done {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				done = true
+				f.Close()
+				break
+			}
+			if err != nil {
+				done = true
+				return csvError("csv_reader", err), true
+			}
+			if header && keys == nil {
+				keys = record
+				continue
+			}
+			return csvRow(record, keys), true
+		}
+		return nil, false
+	}}
+}
+
+// csvStringify writes rows of maps (with a header from the first row's
+// keys) or rows of arrays
+func csvStringify(rows *object.Array, sep rune) object.Object {
+	var out strings.Builder
+	w := csv.NewWriter(&out)
+	w.Comma = sep
+
+	var header []object.Object
+	for i, row := range rows.Elements {
+		var record []string
+		switch row := row.(type) {
+		case *object.Map:
+			if i == 0 {
+				header = []object.Object{}
+				var names []string
+				for _, pair := range row.Ordered() {
+					header = append(header, pair.Key)
+					names = append(names, csvField(pair.Key))
+				}
+				if err := w.Write(names); err != nil {
+					return csvError("csv_stringify", err)
+				}
+			}
+			if header == nil {
+				return newError("csv_stringify(): rows must all be maps or all be arrays")
+			}
+			for _, key := range header {
+				record = append(record, csvField(evalMapIndexExpression(row, key)))
+			}
+		case *object.Array:
+			if header != nil {
+				return newError("csv_stringify(): rows must all be maps or all be arrays")
+			}
+			for _, el := range row.Elements {
+				record = append(record, csvField(el))
+			}
+		default:
+			return newError("csv_stringify(): each row must be a map or an array, got %s", row.Type())
+		}
+		if err := w.Write(record); err != nil {
+			return csvError("csv_stringify", err)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return csvError("csv_stringify", err)
+	}
+	return &object.String{Value: out.String()}
+}
+
+// csvField is a value's text in a csv cell, with null as an empty cell
+func csvField(obj object.Object) string {
+	if obj == NULL {
+		return ""
+	}
+	return textOf(obj)
+}
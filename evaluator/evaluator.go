@@ -2,9 +2,10 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
 	"pearl/ast"
 	"pearl/object"
-	"regexp"
+	"unicode/utf8"
 )
 
 var (
@@ -13,7 +14,19 @@ var (
 	FALSE = &object.Boolean{Value: false}
 )
 
+// Eval evaluates node, tagging any error it raises with the node's position
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	result := eval(node, env)
+	if errObj, ok := result.(*object.Error); ok && errObj.Line == 0 && node != nil {
+		errObj.Line, errObj.Col = node.Position()
+		if file := env.File(); moduleFiles[file] {
+			errObj.File = file
+		}
+	}
+	return result
+}
+
+func eval(node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 
 	case *ast.Program:
@@ -30,9 +43,18 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(val) {
 			return val
 		}
+		if node.Pattern != nil {
+			if err := bindPattern(node.Pattern, val, env); err != nil {
+				return err
+			}
+			return val
+		}
 		env.Set(node.Name.Value, val)
 		return val
 
+	case *ast.YieldStatement:
+		return evalYieldStatement(node, env)
+
 	case *ast.ReturnStatement:
 		if node.ReturnValue == nil {
 			return &object.ReturnValue{Value: NULL}
@@ -49,6 +71,29 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.WhileStatement:
 		return evalWhileStatement(node, env)
 
+	case *ast.TryStatement:
+		return evalTryStatement(node, env)
+
+	case *ast.ImportStatement:
+		return evalImportStatement(node, env)
+
+	case *ast.StructStatement:
+		return evalStructStatement(node, env)
+
+	case *ast.BreakStatement:
+		signal := &object.Break{Line: node.Token.Line, Col: node.Token.Col}
+		if node.Label != nil {
+			signal.Label = node.Label.Value
+		}
+		return signal
+
+	case *ast.ContinueStatement:
+		signal := &object.Continue{Line: node.Token.Line, Col: node.Token.Col}
+		if node.Label != nil {
+			signal.Label = node.Label.Value
+		}
+		return signal
+
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 
@@ -65,11 +110,25 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return NULL
 
 	case *ast.RegexLiteral:
-		re, err := regexp.Compile(node.Pattern)
+		re, err := object.NewRegex(node.Pattern, node.Flags)
 		if err != nil {
 			return newError("invalid regex pattern: %s", err)
 		}
-		return &object.Regex{Pattern: node.Pattern, Regexp: re}
+		return re
+
+	case *ast.SubstituteLiteral:
+		subst, err := object.NewSubstitution(node.Pattern, node.Replacement, node.Flags)
+		if err != nil {
+			return newError("invalid regex pattern: %s", err)
+		}
+		return subst
+
+	case *ast.TransliterateLiteral:
+		tr, err := object.NewTransliteration(node.From, node.To)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return tr
 
 	case *ast.ArrayLiteral:
 		elements := evalExpressions(node.Elements, env)
@@ -112,16 +171,25 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
 
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
+
 	case *ast.FunctionLiteral:
+		if node.Receiver != nil {
+			return evalMethodLiteral(node, env)
+		}
 		params := node.Parameters
 		body := node.Body
-		fn := &object.Function{Parameters: params, Body: body, Env: env, Name: node.Name}
+		fn := &object.Function{Parameters: params, Body: body, Env: env, Name: node.Name, Generator: node.Generator}
 		if node.Name != "" {
 			env.Set(node.Name, fn)
 		}
 		return fn
 
 	case *ast.CallExpression:
+		if me, ok := node.Function.(*ast.MemberExpression); ok {
+			return evalMethodCall(node, me, env)
+		}
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
@@ -130,7 +198,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args, node.Arguments)
+		return addStackFrame(applyFunction(function, args, argNames(node.Arguments)), function, node)
 
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
@@ -141,7 +209,10 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return Hold(evalIndexExpression(left, index))
+
+	case *ast.MemberExpression:
+		return evalMemberExpression(node, env)
 
 	case *ast.PipeExpression:
 		return evalPipeExpression(node, env)
@@ -164,6 +235,8 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return loopSignalError(result)
 		}
 	}
 
@@ -178,7 +251,8 @@ func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) obje
 
 		if result != nil {
 			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ ||
+				rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ {
 				return result
 			}
 		}
@@ -199,6 +273,14 @@ func evalStringLiteral(sl *ast.StringLiteral, env *object.Environment) object.Ob
 			if isError(val) {
 				return val
 			}
+			if part.Spec != "" {
+				str, err := formatValue(val, part.Spec)
+				if err != nil {
+					return err
+				}
+				result += str
+				continue
+			}
 			result += val.Inspect()
 		} else {
 			result += part.Text
@@ -232,16 +314,16 @@ func evalCallArguments(args []ast.CallArg, env *object.Environment) []object.Obj
 }
 
 func evalMapLiteral(node *ast.MapLiteral, env *object.Environment) object.Object {
-	pairs := make(map[object.HashKey]object.MapPair)
+	m := object.NewMap()
 
-	for keyNode, valueNode := range node.Pairs {
+	for _, keyNode := range node.Keys {
+		valueNode := node.Pairs[keyNode]
 		key := Eval(keyNode, env)
 		if isError(key) {
 			return key
 		}
 
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
+		if _, ok := key.(object.Hashable); !ok {
 			return newError("unusable as map key: %s", key.Type())
 		}
 
@@ -250,11 +332,10 @@ func evalMapLiteral(node *ast.MapLiteral, env *object.Environment) object.Object
 			return value
 		}
 
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.MapPair{Key: key, Value: value}
+		m.Set(key, value)
 	}
 
-	return &object.Map{Pairs: pairs}
+	return m
 }
 
 func evalRangeLiteral(node *ast.RangeLiteral, env *object.Environment) object.Object {
@@ -266,27 +347,71 @@ func evalRangeLiteral(node *ast.RangeLiteral, env *object.Environment) object.Ob
 	if isError(end) {
 		return end
 	}
+	var step object.Object
+	if node.Step != nil {
+		step = Eval(node.Step, env)
+		if isError(step) {
+			return step
+		}
+	}
 
-	startInt, ok := start.(*object.Integer)
-	if !ok {
-		return newError("range start must be an integer, got %s", start.Type())
+	return newRange(start, end, step, node.Inclusive)
+}
+
+// newRange makes a range from its bounds and step, which is nil when
+// there isn't one. Any float among them makes a float range.
+func newRange(start, end, step object.Object, inclusive bool) object.Object {
+	isFloat := false
+	for i, val := range []object.Object{start, end, step} {
+		switch val := val.(type) {
+		case nil, *object.Integer:
+		case *object.Float:
+			if math.IsNaN(val.Value) || math.IsInf(val.Value, 0) {
+				return newError("range %s must be a finite number, got %s", rangeParts[i], val.Inspect())
+			}
+			isFloat = true
+		default:
+			return newError("range %s must be a number, got %s", rangeParts[i], val.Type())
+		}
 	}
-	endInt, ok := end.(*object.Integer)
-	if !ok {
-		return newError("range end must be an integer, got %s", end.Type())
+
+	if isFloat {
+		var fstep float64
+		if step != nil {
+			if fstep = toFloat(step); fstep == 0 {
+				return newError("range step can't be 0")
+			}
+		}
+		return object.NewFloatRange(toFloat(start), toFloat(end), fstep, inclusive)
+	}
+
+	var istep int64
+	if step != nil {
+		if istep = step.(*object.Integer).Value; istep == 0 {
+			return newError("range step can't be 0")
+		}
 	}
+	return object.NewRange(start.(*object.Integer).Value, end.(*object.Integer).Value, istep, inclusive)
+}
+
+var rangeParts = []string{"start", "end", "step"}
 
-	return &object.Range{Start: startInt.Value, End: endInt.Value}
+// toFloat returns an integer or float's value as a float64
+func toFloat(n object.Object) float64 {
+	if i, ok := n.(*object.Integer); ok {
+		return float64(i.Value)
+	}
+	return n.(*object.Float).Value
 }
 
 func evalIdentifier(node *ast.Identifier, env *object.Environment) object.Object {
 	if val, ok := env.Get(node.Value); ok {
-		return val
+		return Hold(val)
 	}
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
 	}
-	return newError("undefined variable: %s", node.Value)
+	return newKindError(object.NAME_ERROR, "undefined variable: %s", node.Value)
 }
 
 func evalPrefixExpression(operator string, right object.Object) object.Object {
@@ -296,7 +421,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
 	default:
-		return newError("unknown operator: %s%s", operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s%s", operator, right.Type())
 	}
 }
 
@@ -314,7 +439,7 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	case *object.Float:
 		return &object.Float{Value: -obj.Value}
 	default:
-		return newError("unknown operator: -%s", right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: -%s", right.Type())
 	}
 }
 
@@ -328,12 +453,14 @@ func evalInfixExpression(operator string, left, right object.Object) object.Obje
 		return evalStringInfixExpression(operator, left, right)
 	case left.Type() == object.STRING_OBJ && right.Type() == object.REGEX_OBJ:
 		return evalRegexMatchExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && (right.Type() == object.SUBST_OBJ || right.Type() == object.TRANSLIT_OBJ):
+		return evalRewriteExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -371,7 +498,7 @@ func evalIntegerInfixExpression(operator string, left, right object.Object) obje
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -417,7 +544,7 @@ func evalFloatInfixExpression(operator string, left, right object.Object) object
 	case "!=":
 		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -437,7 +564,7 @@ func evalStringInfixExpression(operator string, left, right object.Object) objec
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -452,7 +579,7 @@ func evalRegexMatchExpression(operator string, left, right object.Object) object
 	case "!~":
 		return nativeBoolToBooleanObject(!matched)
 	default:
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
@@ -491,69 +618,201 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 }
 
-func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
-	iterable := Eval(fs.Iterable, env)
-	if isError(iterable) {
-		return iterable
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
 	}
 
-	var result object.Object = NULL
+	for _, arm := range me.Arms {
+		armEnv := object.NewEnclosedEnvironment(env)
+		matched, err := matchPattern(arm.Pattern, subject, env, armEnv)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
 
-	switch obj := iterable.(type) {
-	case *object.Array:
-		for _, elem := range obj.Elements {
-			innerEnv := object.NewEnclosedEnvironment(env)
-			innerEnv.Set(fs.Variable.Value, elem)
-			result = Eval(fs.Body, innerEnv)
-			if isError(result) {
-				return result
-			}
-			if _, ok := result.(*object.ReturnValue); ok {
-				return result
-			}
+		result := Eval(arm.Body, armEnv)
+		if result == nil {
+			return NULL
 		}
+		return result
+	}
 
-	case *object.Range:
-		for i := obj.Start; i < obj.End; i++ {
-			innerEnv := object.NewEnclosedEnvironment(env)
-			innerEnv.Set(fs.Variable.Value, &object.Integer{Value: i})
-			result = Eval(fs.Body, innerEnv)
-			if isError(result) {
-				return result
+	return NULL
+}
+
+// matchPattern checks subject against a match arm pattern, binding any
+// names it captures into bindEnv. Non-pattern expressions are evaluated
+// in env and compared by value.
+func matchPattern(pattern ast.Expression, subject object.Object, env, bindEnv *object.Environment) (bool, *object.Error) {
+	switch pat := pattern.(type) {
+	case *ast.Identifier:
+		if pat.Value != "_" {
+			bindEnv.Set(pat.Value, subject)
+		}
+		return true, nil
+
+	case *ast.ArrayLiteral:
+		arr, ok := subject.(*object.Array)
+		if !ok || len(arr.Elements) != len(pat.Elements) {
+			return false, nil
+		}
+		for i, el := range pat.Elements {
+			matched, err := matchPattern(el, arr.Elements[i], env, bindEnv)
+			if err != nil || !matched {
+				return false, err
 			}
-			if _, ok := result.(*object.ReturnValue); ok {
-				return result
+		}
+		return true, nil
+
+	case *ast.RangeLiteral:
+		r := evalRangeLiteral(pat, env)
+		if errObj, ok := r.(*object.Error); ok {
+			return false, errObj
+		}
+		return r.(*object.Range).Contains(subject), nil
+
+	case *ast.RegexLiteral:
+		str, ok := subject.(*object.String)
+		if !ok {
+			return false, nil
+		}
+		re, err := object.NewRegex(pat.Pattern, pat.Flags)
+		if err != nil {
+			return false, newError("invalid regex pattern: %s", err)
+		}
+		groups := re.Regexp.FindStringSubmatch(str.Value)
+		if groups == nil {
+			return false, nil
+		}
+
+		// groups holds the whole match and each capture, named captures
+		// are also bound under their own names
+		elements := make([]object.Object, len(groups))
+		for i, g := range groups {
+			elements[i] = &object.String{Value: g}
+		}
+		bindEnv.Set("groups", &object.Array{Elements: elements})
+		for i, name := range re.Regexp.SubexpNames() {
+			if name != "" {
+				bindEnv.Set(name, elements[i])
 			}
 		}
+		return true, nil
 
+	default:
+		val := Eval(pattern, env)
+		if errObj, ok := val.(*object.Error); ok {
+			return false, errObj
+		}
+		return objectsEqual(subject, val), nil
+	}
+}
+
+// objectsEqual compares values structurally, treating ints and floats
+// of the same magnitude as equal
+func objectsEqual(a, b object.Object) bool {
+	switch a := a.(type) {
+	case *object.Integer:
+		switch b := b.(type) {
+		case *object.Integer:
+			return a.Value == b.Value
+		case *object.Float:
+			return float64(a.Value) == b.Value
+		}
+	case *object.Float:
+		switch b := b.(type) {
+		case *object.Integer:
+			return a.Value == float64(b.Value)
+		case *object.Float:
+			return a.Value == b.Value
+		}
 	case *object.String:
-		for _, ch := range obj.Value {
-			innerEnv := object.NewEnclosedEnvironment(env)
-			innerEnv.Set(fs.Variable.Value, &object.String{Value: string(ch)})
-			result = Eval(fs.Body, innerEnv)
-			if isError(result) {
-				return result
-			}
-			if _, ok := result.(*object.ReturnValue); ok {
-				return result
+		if b, ok := b.(*object.String); ok {
+			return a.Value == b.Value
+		}
+	case *object.Boolean:
+		if b, ok := b.(*object.Boolean); ok {
+			return a.Value == b.Value
+		}
+	case *object.Null:
+		return b.Type() == object.NULL_OBJ
+	case *object.Array:
+		b, ok := b.(*object.Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !objectsEqual(a.Elements[i], b.Elements[i]) {
+				return false
 			}
 		}
+		return true
+	}
+	return a == b
+}
 
-	case *object.Map:
-		for _, pair := range obj.Pairs {
-			innerEnv := object.NewEnclosedEnvironment(env)
-			innerEnv.Set(fs.Variable.Value, pair.Key)
-			result = Eval(fs.Body, innerEnv)
-			if isError(result) {
-				return result
+func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(fs.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+
+	var result object.Object = NULL
+	var stop bool
+
+	// step runs the body once, binding val to the loop variable and key
+	// to the index variable of for i, x in ...
+	step := func(key, val object.Object) (object.Object, bool) {
+		innerEnv := object.NewEnclosedEnvironment(env)
+		if fs.Index != nil {
+			innerEnv.Set(fs.Index.Value, key)
+		}
+		if err := bindPattern(fs.Variable, val, innerEnv); err != nil {
+			return err, true
+		}
+		return loopResult(Eval(fs.Body, innerEnv), fs.Label)
+	}
+	index := func(i int) object.Object {
+		return &object.Integer{Value: int64(i)}
+	}
+
+	// for k in m walks the keys, for k, v in m the keys and values
+	if m, ok := iterableOf(iterable).(*object.Map); ok {
+		for _, pair := range m.Ordered() {
+			if fs.Index != nil {
+				result, stop = step(pair.Key, pair.Value)
+			} else {
+				result, stop = step(nil, pair.Key)
 			}
-			if _, ok := result.(*object.ReturnValue); ok {
+			if stop {
 				return result
 			}
 		}
+		return result
+	}
 
-	default:
-		return newError("cannot iterate over %s", iterable.Type())
+	next, err := Iterate(iterable)
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		val, ok := next()
+		if !ok {
+			break
+		}
+		if isError(val) {
+			return val
+		}
+		result, stop = step(index(i), val)
+		if stop {
+			// left early by break, continue, return or an error
+			StopIterating(iterable)
+			return result
+		}
 	}
 
 	return result
@@ -561,6 +820,7 @@ func evalForStatement(fs *ast.ForStatement, env *object.Environment) object.Obje
 
 func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.Object {
 	var result object.Object = NULL
+	var stop bool
 
 	for {
 		condition := Eval(ws.Condition, env)
@@ -571,18 +831,99 @@ func evalWhileStatement(ws *ast.WhileStatement, env *object.Environment) object.
 			break
 		}
 
-		result = Eval(ws.Body, env)
-		if isError(result) {
+		result, stop = loopResult(Eval(ws.Body, env), ws.Label)
+		if stop {
 			return result
 		}
-		if _, ok := result.(*object.ReturnValue); ok {
-			return result
+	}
+
+	return result
+}
+
+// loopResult decides what a loop does with the result of one iteration.
+// Errors, returns and break/continue aimed at an outer loop stop the loop
+// and propagate; a break for this loop stops it and yields null.
+func loopResult(result object.Object, label string) (object.Object, bool) {
+	switch r := result.(type) {
+	case *object.Error, *object.ReturnValue:
+		return result, true
+	case *object.Break:
+		if r.Label == "" || r.Label == label {
+			return NULL, true
 		}
+		return result, true
+	case *object.Continue:
+		if r.Label == "" || r.Label == label {
+			return NULL, false
+		}
+		return result, true
+	case nil:
+		return NULL, false
 	}
+	return result, false
+}
 
+// loopSignalError reports a break or continue that escaped every loop
+func loopSignalError(signal object.Object) *object.Error {
+	var word, label string
+	var err *object.Error
+	switch s := signal.(type) {
+	case *object.Break:
+		word, label = "break", s.Label
+		err = &object.Error{Line: s.Line, Col: s.Col}
+	case *object.Continue:
+		word, label = "continue", s.Label
+		err = &object.Error{Line: s.Line, Col: s.Col}
+	}
+	if label != "" {
+		err.Message = fmt.Sprintf("%s %s: no enclosing loop with that label", word, label)
+	} else {
+		err.Message = fmt.Sprintf("%s outside of a loop", word)
+	}
+	return err
+}
+
+func evalTryStatement(ts *ast.TryStatement, env *object.Environment) object.Object {
+	result := Eval(ts.Body, env)
+
+	errObj, ok := result.(*object.Error)
+	if !ok || errObj.IsExit() {
+		if result == nil {
+			return NULL
+		}
+		return result
+	}
+
+	catchEnv := object.NewEnclosedEnvironment(env)
+	if ts.CatchVar != nil {
+		catchEnv.Set(ts.CatchVar.Value, errorToMap(errObj))
+	}
+
+	result = Eval(ts.Catch, catchEnv)
+	if result == nil {
+		return NULL
+	}
 	return result
 }
 
+// errorToMap turns an error into a plain value a catch block can inspect
+func errorToMap(e *object.Error) *object.Map {
+	kind := e.Kind
+	if kind == "" {
+		kind = object.RUNTIME_ERROR
+	}
+
+	m := object.NewMap()
+	set := func(key string, val object.Object) {
+		m.Set(&object.String{Value: key}, val)
+	}
+	set("message", &object.String{Value: e.Message})
+	set("kind", &object.String{Value: kind})
+	set("line", &object.Integer{Value: int64(e.Line)})
+	set("col", &object.Integer{Value: int64(e.Col)})
+	return m
+}
+
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
@@ -591,6 +932,8 @@ func evalIndexExpression(left, index object.Object) object.Object {
 		return evalStringIndexExpression(left, index)
 	case left.Type() == object.MAP_OBJ:
 		return evalMapIndexExpression(left, index)
+	case left.Type() == object.RANGE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalRangeIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
 	}
@@ -611,35 +954,176 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arr.Elements[idx]
 }
 
+// evalStringIndexExpression returns the character at a code point index
 func evalStringIndexExpression(str, index object.Object) object.Object {
 	s := str.(*object.String)
 	idx := index.(*object.Integer).Value
-	max := int64(len(s.Value) - 1)
 
 	if idx < 0 {
-		idx = int64(len(s.Value)) + idx
+		idx = int64(utf8.RuneCountInString(s.Value)) + idx
+		if idx < 0 {
+			return NULL
+		}
 	}
-	if idx < 0 || idx > max {
+	for _, r := range s.Value {
+		if idx == 0 {
+			return &object.String{Value: string(r)}
+		}
+		idx--
+	}
+	return NULL
+}
+
+// evalRangeIndexExpression works out a range's value at an index, the same
+// as indexing the array it would make
+func evalRangeIndexExpression(rng, index object.Object) object.Object {
+	r := rng.(*object.Range)
+	idx := index.(*object.Integer).Value
+	n, _ := r.Len()
+
+	if idx < 0 {
+		idx = n + idx
+	}
+	if idx < 0 || idx >= n {
 		return NULL
 	}
 
-	return &object.String{Value: string(s.Value[idx])}
+	return r.At(idx)
 }
 
 func evalMapIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Map)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
+	if _, ok := index.(object.Hashable); !ok {
 		return newError("unusable as map key: %s", index.Type())
 	}
 
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	val, ok := hashObject.Get(index)
 	if !ok {
 		return NULL
 	}
 
-	return pair.Value
+	return val
+}
+
+func evalMemberExpression(me *ast.MemberExpression, env *object.Environment) object.Object {
+	obj := Eval(me.Object, env)
+	if isError(obj) {
+		return obj
+	}
+	return Hold(memberValue(obj, me.Member.Value))
+}
+
+// memberValue is obj.name: an export of a module, a key of a map, a
+// field of a struct or a method of a struct type
+func memberValue(obj object.Object, name string) object.Object {
+	switch obj := obj.(type) {
+	case *object.Struct:
+		val, ok := obj.Get(name)
+		if !ok {
+			return newKindError(object.NAME_ERROR, "%s has no field %s", obj.Def.Name, name)
+		}
+		return val
+	case *object.StructType:
+		val, ok := obj.Method(name)
+		if !ok {
+			return newKindError(object.NAME_ERROR, "%s has no method %s", obj.Name, name)
+		}
+		return val
+	case *object.Module:
+		val, ok := obj.Export(name)
+		if !ok {
+			return newKindError(object.NAME_ERROR, "module %s has no export %s", obj.Name, name)
+		}
+		return val
+	case *object.Map:
+		return evalMapIndexExpression(obj, &object.String{Value: name})
+	default:
+		return newKindError(object.TYPE_ERROR, "cannot access .%s on %s", name, obj.Type())
+	}
+}
+
+func assignMember(obj object.Object, name string, val object.Object) object.Object {
+	switch obj := obj.(type) {
+	case *object.Struct:
+		if !obj.Set(name, val) {
+			return newKindError(object.NAME_ERROR, "%s has no field %s", obj.Def.Name, name)
+		}
+		return val
+	case *object.Map:
+		return assignIndex(obj, &object.String{Value: name}, val)
+	case *object.Module:
+		return newError("cannot assign to %s.%s, module exports are read only", obj.Name, name)
+	default:
+		return newKindError(object.TYPE_ERROR, "cannot set .%s on %s", name, obj.Type())
+	}
+}
+
+// memberCallee finds what recv.name(...) calls when name is a field of
+// recv rather than a method: a module export, a function stored in a map
+// or struct field, or a method called through its struct type
+func memberCallee(recv object.Object, name string) (object.Object, bool) {
+	switch recv := recv.(type) {
+	case *object.Module, *object.StructType:
+		return memberValue(recv, name), true
+	case *object.Struct:
+		if val, ok := recv.Get(name); ok {
+			return val, true
+		}
+	case *object.Map:
+		if val, ok := recv.Get(&object.String{Value: name}); ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// evalMethodCall handles recv.name(args). Unless name is a field of recv,
+// it calls the function called name with recv prepended to the arguments,
+// the same way evalPipeExpression does, so s.upper() is upper(s).
+func evalMethodCall(node *ast.CallExpression, me *ast.MemberExpression, env *object.Environment) object.Object {
+	recv := Eval(me.Object, env)
+	if isError(recv) {
+		return recv
+	}
+
+	fn, isField := memberCallee(recv, me.Member.Value)
+	if isError(fn) {
+		return fn
+	}
+
+	args := evalCallArguments(node.Arguments, env)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+	names := argNames(node.Arguments)
+
+	if !isField {
+		var ok bool
+		fn, ok = lookupMethod(recv, me.Member.Value, env)
+		if !ok {
+			return newKindError(object.TYPE_ERROR, "%s has no method %s", typeName(recv), me.Member.Value)
+		}
+		args = append([]object.Object{recv}, args...)
+		names = append([]string{""}, names...)
+	}
+
+	return addStackFrame(applyFunction(fn, args, names), fn, node)
+}
+
+// lookupMethod resolves a method name: methods of recv's struct first,
+// then the way evalIdentifier resolves a function name
+func lookupMethod(recv object.Object, name string, env *object.Environment) (object.Object, bool) {
+	if fn, ok := structMethod(recv, name); ok {
+		return fn, true
+	}
+	if val, ok := env.Get(name); ok {
+		return val, true
+	}
+	if builtin, ok := builtins[name]; ok {
+		return builtin, true
+	}
+	return nil, false
 }
 
 func evalPipeExpression(pe *ast.PipeExpression, env *object.Environment) object.Object {
@@ -664,14 +1148,15 @@ func evalPipeExpression(pe *ast.PipeExpression, env *object.Environment) object.
 			args = append(args, arg)
 		}
 
-		return applyFunction(fn, args, nil)
+		names := append([]string{""}, argNames(right.Arguments)...)
+		return addStackFrame(applyFunction(fn, args, names), fn, right)
 
 	case *ast.Identifier:
 		fn := evalIdentifier(right, env)
 		if isError(fn) {
 			return fn
 		}
-		return applyFunction(fn, []object.Object{left}, nil)
+		return addStackFrame(applyFunction(fn, []object.Object{left}, nil), fn, right)
 
 	default:
 		return newError("right side of pipe must be a function call")
@@ -687,7 +1172,7 @@ func evalAssignExpression(ae *ast.AssignExpression, env *object.Environment) obj
 	switch target := ae.Name.(type) {
 	case *ast.Identifier:
 		if !env.Update(target.Value, val) {
-			return newError("undefined variable: %s", target.Value)
+			return newKindError(object.NAME_ERROR, "undefined variable: %s", target.Value)
 		}
 		return val
 
@@ -701,40 +1186,81 @@ func evalAssignExpression(ae *ast.AssignExpression, env *object.Environment) obj
 			return index
 		}
 
-		switch obj := left.(type) {
-		case *object.Array:
-			idx := index.(*object.Integer).Value
-			if idx < 0 || idx >= int64(len(obj.Elements)) {
-				return newError("array index out of bounds: %d", idx)
-			}
-			obj.Elements[idx] = val
-			return val
+		return assignIndex(left, index, val)
 
-		case *object.Map:
-			key, ok := index.(object.Hashable)
-			if !ok {
-				return newError("unusable as map key: %s", index.Type())
-			}
-			obj.Pairs[key.HashKey()] = object.MapPair{Key: index, Value: val}
-			return val
-
-		default:
-			return newError("cannot assign to index of %s", left.Type())
+	case *ast.MemberExpression:
+		obj := Eval(target.Object, env)
+		if isError(obj) {
+			return obj
 		}
+		return assignMember(obj, target.Member.Value, val)
 
 	default:
 		return newError("cannot assign to this expression")
 	}
 }
 
-func applyFunction(fn object.Object, args []object.Object, callArgs []ast.CallArg) object.Object {
+func assignIndex(left, index, val object.Object) object.Object {
+	switch obj := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be an integer, got %s", index.Type())
+		}
+		idx := i.Value
+		if idx < 0 || idx >= int64(len(obj.Elements)) {
+			return newError("array index out of bounds: %d", idx)
+		}
+		obj.Elements[idx] = val
+		return val
+
+	case *object.Map:
+		if _, ok := index.(object.Hashable); !ok {
+			return newError("unusable as map key: %s", index.Type())
+		}
+		obj.Set(index, val)
+		return val
+
+	default:
+		return newError("cannot assign to index of %s", left.Type())
+	}
+}
+
+func applyFunction(fn object.Object, args []object.Object, names []string) object.Object {
+	args, names, err := spreadArgs(args, names)
+	if err != nil {
+		return err
+	}
+
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args, callArgs)
+		extendedEnv, err := extendFunctionEnv(fn, args, names)
+		if err != nil {
+			return err
+		}
+		if fn.Generator {
+			return callGenerator(fn, extendedEnv)
+		}
 		evaluated := Eval(fn.Body, extendedEnv)
+		if isLoopSignal(evaluated) {
+			return loopSignalError(evaluated)
+		}
 		return unwrapReturnValue(evaluated)
 
+	case *object.Closure:
+		if fn.Call == nil {
+			return newError("compiled functions need the vm to run")
+		}
+		return fn.Call(fn, args)
+
+	case *object.StructType:
+		return newStruct(fn, applyFunction(fn.Init, args, names))
+
 	case *object.Builtin:
+		args, err := bindBuiltinArgs(fn, args, names)
+		if err != nil {
+			return err
+		}
 		result := fn.Fn(args...)
 		if result != nil {
 			return result
@@ -742,49 +1268,71 @@ func applyFunction(fn object.Object, args []object.Object, callArgs []ast.CallAr
 		return NULL
 
 	default:
-		return newError("not a function: %s", fn.Type())
+		return newKindError(object.TYPE_ERROR, "not a function: %s", fn.Type())
 	}
 }
 
-func extendFunctionEnv(fn *object.Function, args []object.Object, callArgs []ast.CallArg) *object.Environment {
+// addStackFrame records the call site on errors leaving a Pearl function
+func addStackFrame(result object.Object, fn object.Object, call ast.Node) object.Object {
+	errObj, ok := result.(*object.Error)
+	if !ok {
+		return result
+	}
+	function, ok := fn.(*object.Function)
+	if !ok {
+		return result
+	}
+
+	name := function.Name
+	if name == "" {
+		name = "<anonymous fn>"
+	}
+	line, col := call.Position()
+	if errObj.Line == 0 {
+		errObj.Line, errObj.Col = line, col
+	}
+	errObj.Stack = append(errObj.Stack, object.Frame{Function: name, Line: line, Col: col})
+	return errObj
+}
+
+func extendFunctionEnv(fn *object.Function, args []object.Object, names []string) (*object.Environment, *object.Error) {
 	env := object.NewEnclosedEnvironment(fn.Env)
 
-	namedArgs := make(map[string]object.Object)
-	positionalArgs := []object.Object{}
+	params := make([]string, len(fn.Parameters))
+	for i, param := range fn.Parameters {
+		params[i] = param.Name.Value
+	}
+	variadic := len(params) > 0 && fn.Parameters[len(params)-1].Rest
+	bound, err := bindArgs(fn.Name, params, variadic, args, names)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, arg := range args {
-		if callArgs != nil && i < len(callArgs) && callArgs[i].Name != "" {
-			namedArgs[callArgs[i].Name] = arg
-		} else {
-			positionalArgs = append(positionalArgs, arg)
+	for i, param := range fn.Parameters {
+		val := bound[i]
+		if val == nil && param.Default != nil {
+			val = Eval(param.Default, fn.Env)
+			if isError(val) {
+				return nil, val.(*object.Error)
+			}
+		} else if val == nil {
+			val = NULL
 		}
+		env.Set(param.Name.Value, val)
 	}
 
-	posIdx := 0
+	// destructure pattern parameters once every argument is bound
 	for _, param := range fn.Parameters {
-		name := param.Name.Value
-
-		if val, ok := namedArgs[name]; ok {
-			env.Set(name, val)
+		if param.Pattern == nil {
 			continue
 		}
-
-		if posIdx < len(positionalArgs) {
-			env.Set(name, positionalArgs[posIdx])
-			posIdx++
-			continue
-		}
-
-		if param.Default != nil {
-			val := Eval(param.Default, fn.Env)
-			env.Set(name, val)
-			continue
+		val, _ := env.Get(param.Name.Value)
+		if err := bindPattern(param.Pattern, val, env); err != nil {
+			return nil, err
 		}
-
-		env.Set(name, NULL)
 	}
 
-	return env
+	return env, nil
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -822,6 +1370,18 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+func newKindError(kind string, format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: kind}
+}
+
+func isLoopSignal(obj object.Object) bool {
+	if obj != nil {
+		rt := obj.Type()
+		return rt == object.BREAK_OBJ || rt == object.CONTINUE_OBJ
+	}
+	return false
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -0,0 +1,375 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"pearl/object"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fmtSpec is a parsed format spec, the part after the colon in
+// "{price:>10.2f}". It follows Python's format mini-language:
+//
+//	[[fill]align][sign][#][0][width][,][.precision][type]
+type fmtSpec struct {
+	fill  rune
+	align byte // '<', '>', '^', or 0 for the default of the value's type
+	sign  byte // '+', ' ', or 0 to only show minus signs
+	alt   bool // # adds a 0x, 0o or 0b prefix
+	zero  bool // 0 pads numbers with zeros after the sign
+	width int
+	comma bool
+	prec  int // -1 when not given
+	verb  byte
+}
+
+const specVerbs = "bcdeEfFgGosxX%"
+
+// parseSpec parses a format spec, reporting false if it isn't one
+func parseSpec(spec string) (fmtSpec, bool) {
+	s := fmtSpec{fill: ' ', prec: -1}
+	i := 0
+
+	if r, size := utf8.DecodeRuneInString(spec); size > 0 && size < len(spec) && strings.IndexByte("<>^", spec[size]) >= 0 {
+		s.fill, s.align = r, spec[size]
+		i = size + 1
+	} else if len(spec) > 0 && strings.IndexByte("<>^", spec[0]) >= 0 {
+		s.align = spec[0]
+		i = 1
+	}
+	if i < len(spec) && (spec[i] == '+' || spec[i] == '-' || spec[i] == ' ') {
+		if spec[i] != '-' {
+			s.sign = spec[i]
+		}
+		i++
+	}
+	if i < len(spec) && spec[i] == '#' {
+		s.alt = true
+		i++
+	}
+	if i < len(spec) && spec[i] == '0' {
+		s.zero = true
+		i++
+	}
+	start := i
+	for i < len(spec) && spec[i] >= '0' && spec[i] <= '9' {
+		i++
+	}
+	if i > start {
+		s.width, _ = strconv.Atoi(spec[start:i])
+	}
+	if i < len(spec) && spec[i] == ',' {
+		s.comma = true
+		i++
+	}
+	if i < len(spec) && spec[i] == '.' {
+		i++
+		start = i
+		for i < len(spec) && spec[i] >= '0' && spec[i] <= '9' {
+			i++
+		}
+		if i == start {
+			return s, false
+		}
+		s.prec, _ = strconv.Atoi(spec[start:i])
+	}
+	if i < len(spec) && strings.IndexByte(specVerbs, spec[i]) >= 0 {
+		s.verb = spec[i]
+		i++
+	}
+	return s, i == len(spec)
+}
+
+// formatValue formats val as spec describes, for format() and {x:spec}
+// in strings
+func formatValue(val object.Object, spec string) (string, *object.Error) {
+	s, ok := parseSpec(spec)
+	if !ok {
+		return "", newError("invalid format spec %q", spec)
+	}
+
+	verb := s.verb
+	if verb == 0 {
+		switch val.(type) {
+		case *object.Integer:
+			verb = 'd'
+		case *object.Float:
+			verb = 'g'
+			if s.prec >= 0 {
+				verb = 'f'
+			}
+		}
+	}
+
+	var sign, body string
+	switch verb {
+	case 0, 's':
+		if s.sign != 0 || s.comma || s.alt {
+			return "", newKindError(object.TYPE_ERROR, "format spec %q needs a number, got %s", spec, typeName(val))
+		}
+		body = val.Inspect()
+		if str, ok := val.(*object.String); ok {
+			body = str.Value
+		}
+		if verb == 's' && s.prec >= 0 && utf8.RuneCountInString(body) > s.prec {
+			body = string([]rune(body)[:s.prec])
+		}
+
+	case 'd', 'x', 'X', 'o', 'b', 'c':
+		n, ok := val.(*object.Integer)
+		if !ok {
+			return "", newKindError(object.TYPE_ERROR, "format spec %q needs an integer, got %s", spec, typeName(val))
+		}
+		if verb == 'c' {
+			body = string(rune(n.Value))
+			break
+		}
+		v := n.Value
+		if v < 0 {
+			sign = "-"
+		} else if s.sign != 0 {
+			sign = string(s.sign)
+		}
+		mag := uint64(v)
+		if v < 0 {
+			mag = -mag
+		}
+		switch verb {
+		case 'd':
+			body = strconv.FormatUint(mag, 10)
+			if s.comma {
+				body = groupThousands(body)
+			}
+		case 'x', 'X':
+			body = strconv.FormatUint(mag, 16)
+			if verb == 'X' {
+				body = strings.ToUpper(body)
+			}
+		case 'o':
+			body = strconv.FormatUint(mag, 8)
+		case 'b':
+			body = strconv.FormatUint(mag, 2)
+		}
+		if s.alt && verb != 'd' {
+			sign += "0" + string(verb)
+		}
+
+	default:
+		var f float64
+		switch n := val.(type) {
+		case *object.Integer:
+			f = float64(n.Value)
+		case *object.Float:
+			f = n.Value
+		default:
+			return "", newKindError(object.TYPE_ERROR, "format spec %q needs a number, got %s", spec, typeName(val))
+		}
+		if verb == '%' {
+			f *= 100
+		}
+		if math.Signbit(f) && !math.IsNaN(f) {
+			sign = "-"
+			f = -f
+		} else if s.sign != 0 {
+			sign = string(s.sign)
+		}
+		prec := s.prec
+		if prec < 0 && verb != 'g' && verb != 'G' {
+			prec = 6
+		}
+		switch verb {
+		case 'f', 'F', '%':
+			body = strconv.FormatFloat(f, 'f', prec, 64)
+		case 'e', 'E', 'g', 'G':
+			body = strconv.FormatFloat(f, verb, prec, 64)
+		}
+		if s.comma {
+			whole, frac, _ := strings.Cut(body, ".")
+			body = groupThousands(whole)
+			if frac != "" {
+				body += "." + frac
+			}
+		}
+		if verb == '%' {
+			body += "%"
+		}
+	}
+
+	return pad(sign, body, s, verb != 0 && verb != 's' && verb != 'c'), nil
+}
+
+// pad fills out sign+body to the spec's width. Numbers go on the right
+// unless the spec says otherwise, and 0 puts the zeros after the sign.
+func pad(sign, body string, s fmtSpec, numeric bool) string {
+	n := s.width - utf8.RuneCountInString(sign) - utf8.RuneCountInString(body)
+	if n <= 0 {
+		return sign + body
+	}
+	if s.zero && s.align == 0 {
+		if numeric {
+			return sign + strings.Repeat("0", n) + body
+		}
+		s.fill = '0'
+	}
+
+	align := s.align
+	if align == 0 {
+		align = '<'
+		if numeric {
+			align = '>'
+		}
+	}
+	fill := string(s.fill)
+	switch align {
+	case '>':
+		return strings.Repeat(fill, n) + sign + body
+	case '^':
+		return strings.Repeat(fill, n/2) + sign + body + strings.Repeat(fill, n-n/2)
+	default:
+		return sign + body + strings.Repeat(fill, n)
+	}
+}
+
+// groupThousands puts commas between groups of three digits
+func groupThousands(digits string) string {
+	var out strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			out.WriteByte(',')
+		}
+		out.WriteRune(d)
+	}
+	return out.String()
+}
+
+// formatString fills in the fields of format(): {} takes the next
+// argument and {1} a given one, and either can end in :spec. {{ and }}
+// are literal braces, and braces around anything else are left as they
+// are, so a string literal's {{name}} comes out as {name}.
+func formatString(format string, args []object.Object) (string, *object.Error) {
+	var out strings.Builder
+	next := 0
+
+	for i := 0; i < len(format); i++ {
+		if strings.HasPrefix(format[i:], "{{") || strings.HasPrefix(format[i:], "}}") {
+			out.WriteByte(format[i])
+			i++
+			continue
+		}
+		if format[i] != '{' {
+			out.WriteByte(format[i])
+			continue
+		}
+		end := strings.IndexByte(format[i:], '}')
+		if end < 0 {
+			return "", newError("format() has an unclosed { in %q", format)
+		}
+		field, spec, _ := strings.Cut(format[i+1:i+end], ":")
+		if strings.Trim(field, "0123456789") != "" {
+			out.WriteString(format[i : i+end+1])
+			i += end
+			continue
+		}
+		i += end
+
+		var val object.Object
+		if field == "" {
+			if next >= len(args) {
+				return "", newError("format() needs more than %d %s", len(args), plural(len(args), "argument"))
+			}
+			val = args[next]
+			next++
+		} else {
+			n, err := strconv.Atoi(field)
+			if err != nil || n < 0 || n >= len(args) {
+				return "", newError("format() has no argument {%s}", field)
+			}
+			val = args[n]
+		}
+
+		s, err := formatValue(val, spec)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(s)
+	}
+	return out.String(), nil
+}
+
+// sprintf is printf-style formatting with %[flags][width][.precision]verb,
+// using the verbs C and Go share
+func sprintf(name, format string, args []object.Object) (string, *object.Error) {
+	var out strings.Builder
+	next := 0
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+		j := i + 1
+		for j < len(format) && strings.IndexByte("-+ #0", format[j]) >= 0 {
+			j++
+		}
+		for j < len(format) && (format[j] >= '0' && format[j] <= '9' || format[j] == '.') {
+			j++
+		}
+		if j >= len(format) {
+			return "", newError("%s() format ends in the middle of %q", name, format[i:])
+		}
+		verb := format[j]
+		directive := format[i:j]
+		i = j
+
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if next >= len(args) {
+			return "", newError("%s() needs more than %d %s", name, len(args), plural(len(args), "argument"))
+		}
+		arg := args[next]
+		next++
+
+		var val any
+		switch verb {
+		case 'd', 'i', 'x', 'X', 'o', 'b', 'c':
+			n, ok := arg.(*object.Integer)
+			if !ok {
+				return "", newKindError(object.TYPE_ERROR, "%s() %%%c needs an integer, got %s", name, verb, typeName(arg))
+			}
+			if verb == 'i' {
+				verb = 'd'
+			}
+			val = n.Value
+		case 'f', 'F', 'e', 'E', 'g', 'G':
+			switch n := arg.(type) {
+			case *object.Integer:
+				val = float64(n.Value)
+			case *object.Float:
+				val = n.Value
+			default:
+				return "", newKindError(object.TYPE_ERROR, "%s() %%%c needs a number, got %s", name, verb, typeName(arg))
+			}
+		case 's', 'v', 'q':
+			if s, ok := arg.(*object.String); ok {
+				val = s.Value
+			} else {
+				val = arg.Inspect()
+			}
+			if verb == 'v' {
+				verb = 's'
+			}
+		default:
+			return "", newError("%s() doesn't know %%%c", name, verb)
+		}
+		out.WriteString(fmt.Sprintf(directive+string(verb), val))
+	}
+
+	if next < len(args) {
+		return "", newError("%s() got %d %s but the format uses %d", name, len(args), plural(len(args), "argument"), next)
+	}
+	return out.String(), nil
+}
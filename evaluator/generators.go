@@ -0,0 +1,119 @@
+package evaluator
+
+import (
+	"pearl/ast"
+	"pearl/object"
+	"runtime"
+)
+
+// NewGenerator returns an iterator over the values run yields. run gets a
+// goroutine of its own, started by the first Next and paused at each yield
+// until the next value is asked for, so it and the code iterating never
+// run at the same time. What run returns ends the iteration; an *Error is
+// passed on to the caller. Closing the iterator, or dropping the last
+// reference to it, ends a generator paused part way.
+func NewGenerator(name string, run func(yield func(object.Object)) object.Object) *object.Iterator {
+	g := &generator{
+		run:    run,
+		values: make(chan object.Object),
+		resume: make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	// the goroutine only holds the channels, so g goes away once nothing
+	// can ask it for values
+	runtime.SetFinalizer(g, (*generator).close)
+
+	if name == "" {
+		name = "generator"
+	}
+	return &object.Iterator{Name: name, Next: g.next, Close: g.close}
+}
+
+type generator struct {
+	run           func(yield func(object.Object)) object.Object
+	values        chan object.Object
+	resume, stop  chan struct{}
+	started, done bool
+}
+
+// generatorClosed unwinds a generator's goroutine from the yield it's
+// paused at when the generator is closed
+type generatorClosed struct{}
+
+func (g *generator) next() (object.Object, bool) {
+	if g.done {
+		return nil, false
+	}
+	if g.started {
+		g.resume <- struct{}{}
+	} else {
+		g.started = true
+		go runGenerator(g.run, g.values, g.resume, g.stop)
+	}
+
+	val, ok := <-g.values
+	if !ok || isError(val) {
+		g.done = true
+	}
+	return val, ok
+}
+
+func (g *generator) close() {
+	if g.started && !g.done {
+		close(g.stop)
+	}
+	g.done = true
+}
+
+func runGenerator(run func(yield func(object.Object)) object.Object, values chan object.Object, resume, stop chan struct{}) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(generatorClosed); !ok {
+				panic(r)
+			}
+		}
+	}()
+
+	yield := func(val object.Object) {
+		values <- val
+		select {
+		case <-resume:
+		case <-stop:
+			panic(generatorClosed{})
+		}
+	}
+	if result := run(yield); isError(result) {
+		values <- result
+	}
+	close(values)
+}
+
+// callGenerator starts a call to a function that yields, with the
+// arguments already bound in env
+func callGenerator(fn *object.Function, env *object.Environment) object.Object {
+	return NewGenerator(fn.Name, func(yield func(object.Object)) object.Object {
+		env.SetYield(yield)
+		evaluated := Eval(fn.Body, env)
+		if isLoopSignal(evaluated) {
+			return loopSignalError(evaluated)
+		}
+		return unwrapReturnValue(evaluated)
+	})
+}
+
+func evalYieldStatement(ys *ast.YieldStatement, env *object.Environment) object.Object {
+	yield := env.Yield()
+	if yield == nil {
+		return newError("yield outside a generator")
+	}
+
+	var val object.Object = NULL
+	if ys.Value != nil {
+		val = Eval(ys.Value, env)
+		if isError(val) {
+			return val
+		}
+	}
+	yield(val)
+	return NULL
+}
@@ -0,0 +1,221 @@
+package evaluator
+
+import (
+	"pearl/object"
+)
+
+// Iterate returns a function producing obj's values one at a time, the way
+// a for loop walks it: elements, numbers in a range, characters, map keys,
+// lines of a file or whatever an iterator gives. Like Iterator.Next it
+// reports false when done, or returns an *Error as the value.
+func Iterate(obj object.Object) (func() (object.Object, bool), *object.Error) {
+	switch obj := iterableOf(obj).(type) {
+	case *object.Array:
+		elements := obj.Elements
+		i := 0
+		return func() (object.Object, bool) {
+			if i >= len(elements) {
+				return nil, false
+			}
+			i++
+			return elements[i-1], true
+		}, nil
+
+	case *object.Range:
+		n, _ := obj.Len()
+		i := int64(0)
+		return func() (object.Object, bool) {
+			if i >= n {
+				return nil, false
+			}
+			i++
+			return obj.At(i - 1), true
+		}, nil
+
+	case *object.String:
+		runes := []rune(obj.Value)
+		i := 0
+		return func() (object.Object, bool) {
+			if i >= len(runes) {
+				return nil, false
+			}
+			i++
+			return &object.String{Value: string(runes[i-1])}, true
+		}, nil
+
+	case *object.Map:
+		pairs := obj.Ordered()
+		i := 0
+		return func() (object.Object, bool) {
+			if i >= len(pairs) {
+				return nil, false
+			}
+			i++
+			return pairs[i-1].Key, true
+		}, nil
+
+	case *object.Iterator:
+		return obj.Next, nil
+
+	case *object.File:
+		return func() (object.Object, bool) {
+			line, ok, err := obj.ReadLine()
+			if err != nil {
+				return ioError("cannot read", obj.Path, err), true
+			}
+			if !ok {
+				obj.Close()
+				return nil, false
+			}
+			return &object.String{Value: line}, true
+		}, nil
+
+	default:
+		return nil, newError("cannot iterate over %s", obj.Type())
+	}
+}
+
+// StopIterating releases what obj holds when iterating it ends before the
+// end, ending a generator paused at a yield. An iterator is only stopped
+// if nothing else holds it, like a generator call passed straight to a
+// loop or take(); one read from a variable may still be asked for more.
+// Files are left open for close(). Stopping twice, or stopping something
+// that holds nothing, does nothing.
+func StopIterating(obj object.Object) {
+	if it, ok := obj.(*object.Iterator); ok && !it.Held && it.Close != nil {
+		it.Close()
+	}
+}
+
+// Hold marks an iterator as held, when it's read from a variable, element
+// or field
+func Hold(obj object.Object) object.Object {
+	if it, ok := obj.(*object.Iterator); ok {
+		it.Held = true
+	}
+	return obj
+}
+
+// stopper returns a function calling StopIterating on obj, for the lazy
+// builtins to stop their source with
+func stopper(obj object.Object) func() {
+	return func() { StopIterating(obj) }
+}
+
+// iterArg is Iterate for a builtin's argument, naming the builtin in the
+// error
+func iterArg(name string, obj object.Object) (func() (object.Object, bool), *object.Error) {
+	next, err := Iterate(obj)
+	if err != nil {
+		return nil, newError("%s() can't iterate over %s", name, obj.Type())
+	}
+	return next, nil
+}
+
+// collect reads everything left in next into an array
+func collect(next func() (object.Object, bool)) object.Object {
+	elements := []object.Object{}
+	for {
+		val, ok := next()
+		if !ok {
+			return &object.Array{Elements: elements}
+		}
+		if isError(val) {
+			return val
+		}
+		elements = append(elements, val)
+	}
+}
+
+// lazyMap is map() over anything but an array: an iterator calling fn as
+// each value is asked for. stop stops the source.
+func lazyMap(next func() (object.Object, bool), stop func(), fn object.Object) object.Object {
+	i := int64(0)
+	return &object.Iterator{Name: "map", Close: stop, Next: func() (object.Object, bool) {
+		val, ok := next()
+		if !ok || isError(val) {
+			return val, ok
+		}
+		i++
+		return callCallback(fn, []object.Object{val}, &object.Integer{Value: i - 1}), true
+	}}
+}
+
+// lazyFilter is filter() over anything but an array
+func lazyFilter(next func() (object.Object, bool), stop func(), fn object.Object) object.Object {
+	i := int64(0)
+	return &object.Iterator{Name: "filter", Close: stop, Next: func() (object.Object, bool) {
+		for {
+			val, ok := next()
+			if !ok || isError(val) {
+				return val, ok
+			}
+			i++
+			keep := callCallback(fn, []object.Object{val}, &object.Integer{Value: i - 1})
+			if isError(keep) {
+				return keep, true
+			}
+			if isTruthyBuiltin(keep) {
+				return val, true
+			}
+		}
+	}}
+}
+
+// lazyTake gives the first n values of next, without reading any more.
+// The source is stopped once they've been given, unless it's held, so a
+// generator call passed straight in doesn't wait for a value that's never
+// asked for.
+func lazyTake(next func() (object.Object, bool), stop func(), n int64) object.Object {
+	return &object.Iterator{Name: "take", Close: stop, Next: func() (object.Object, bool) {
+		if n <= 0 {
+			stop()
+			return nil, false
+		}
+		n--
+		return next()
+	}}
+}
+
+// lazySkip drops the first n values of next when the first value is asked
+// for
+func lazySkip(next func() (object.Object, bool), stop func(), n int64) object.Object {
+	return &object.Iterator{Name: "skip", Close: stop, Next: func() (object.Object, bool) {
+		for ; n > 0; n-- {
+			val, ok := next()
+			if !ok || isError(val) {
+				n = 0
+				return val, ok
+			}
+		}
+		return next()
+	}}
+}
+
+// lazyZip pairs up the values of each source, stopping at the shortest.
+// stops stop the sources, and are called for the longer ones once the
+// shortest runs out.
+func lazyZip(sources []func() (object.Object, bool), stops []func()) *object.Iterator {
+	done := false
+	stopAll := func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	return &object.Iterator{Name: "zip", Close: stopAll, Next: func() (object.Object, bool) {
+		if done {
+			return nil, false
+		}
+		tuple := make([]object.Object, len(sources))
+		for i, next := range sources {
+			val, ok := next()
+			if !ok || isError(val) {
+				done = true
+				stopAll()
+				return val, ok
+			}
+			tuple[i] = val
+		}
+		return &object.Array{Elements: tuple}, true
+	}}
+}
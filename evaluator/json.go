@@ -0,0 +1,447 @@
+package evaluator
+
+import (
+	"fmt"
+	"math"
+	"pearl/object"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonParser is a small recursive descent parser that builds Pearl
+// values directly
+type jsonParser struct {
+	input string
+	pos   int
+}
+
+func jsonParse(input string) object.Object {
+	p := &jsonParser{input: input}
+	p.skipSpace()
+	val := p.parseValue()
+	if isError(val) {
+		return val
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return p.errorf("unexpected %s after the value", p.describe())
+	}
+	return val
+}
+
+// errorf reports a problem at the current position in the json text
+func (p *jsonParser) errorf(format string, a ...interface{}) *object.Error {
+	line, col := 1, 1
+	for _, ch := range p.input[:p.pos] {
+		if ch == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return newError("json_parse(): line %d, col %d: %s", line, col, fmt.Sprintf(format, a...))
+}
+
+// describe names the character at the current position for errors
+func (p *jsonParser) describe() string {
+	if p.pos >= len(p.input) {
+		return "end of input"
+	}
+	r, _ := utf8.DecodeRuneInString(p.input[p.pos:])
+	return strconv.QuoteRune(r)
+}
+
+func (p *jsonParser) skipSpace() {
+	for p.pos < len(p.input) {
+		switch p.input[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *jsonParser) parseValue() object.Object {
+	if p.pos >= len(p.input) {
+		return p.errorf("unexpected end of input")
+	}
+
+	switch ch := p.input[p.pos]; {
+	case ch == '{':
+		return p.parseObject()
+	case ch == '[':
+		return p.parseArray()
+	case ch == '"':
+		s, err := p.parseString()
+		if err != nil {
+			return err
+		}
+		return &object.String{Value: s}
+	case ch == '-' || (ch >= '0' && ch <= '9'):
+		return p.parseNumber()
+	case strings.HasPrefix(p.input[p.pos:], "true"):
+		p.pos += 4
+		return TRUE
+	case strings.HasPrefix(p.input[p.pos:], "false"):
+		p.pos += 5
+		return FALSE
+	case strings.HasPrefix(p.input[p.pos:], "null"):
+		p.pos += 4
+		return NULL
+	default:
+		return p.errorf("unexpected %s", p.describe())
+	}
+}
+
+func (p *jsonParser) parseObject() object.Object {
+	m := object.NewMap()
+	p.pos++ // {
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == '}' {
+		p.pos++
+		return m
+	}
+
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != '"' {
+			return p.errorf("expected a string key, got %s", p.describe())
+		}
+		key, err := p.parseString()
+		if err != nil {
+			return err
+		}
+
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ':' {
+			return p.errorf("expected ':' after key, got %s", p.describe())
+		}
+		p.pos++
+		p.skipSpace()
+
+		val := p.parseValue()
+		if isError(val) {
+			return val
+		}
+		m.Set(&object.String{Value: key}, val)
+
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return p.errorf("unterminated object")
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case '}':
+			p.pos++
+			return m
+		default:
+			return p.errorf("expected ',' or '}' in object, got %s", p.describe())
+		}
+	}
+}
+
+func (p *jsonParser) parseArray() object.Object {
+	arr := &object.Array{Elements: []object.Object{}}
+	p.pos++ // [
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == ']' {
+		p.pos++
+		return arr
+	}
+
+	for {
+		p.skipSpace()
+		val := p.parseValue()
+		if isError(val) {
+			return val
+		}
+		arr.Elements = append(arr.Elements, val)
+
+		p.skipSpace()
+		if p.pos >= len(p.input) {
+			return p.errorf("unterminated array")
+		}
+		switch p.input[p.pos] {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return arr
+		default:
+			return p.errorf("expected ',' or ']' in array, got %s", p.describe())
+		}
+	}
+}
+
+func (p *jsonParser) parseString() (string, *object.Error) {
+	p.pos++ // opening quote
+	var out strings.Builder
+
+	for p.pos < len(p.input) {
+		ch := p.input[p.pos]
+		switch {
+		case ch == '"':
+			p.pos++
+			return out.String(), nil
+		case ch < 0x20:
+			return "", p.errorf("control character in string")
+		case ch != '\\':
+			out.WriteByte(ch)
+			p.pos++
+			continue
+		}
+
+		// escape sequence
+		p.pos++
+		if p.pos >= len(p.input) {
+			break
+		}
+		switch esc := p.input[p.pos]; esc {
+		case '"', '\\', '/':
+			out.WriteByte(esc)
+		case 'b':
+			out.WriteByte('\b')
+		case 'f':
+			out.WriteByte('\f')
+		case 'n':
+			out.WriteByte('\n')
+		case 'r':
+			out.WriteByte('\r')
+		case 't':
+			out.WriteByte('\t')
+		case 'u':
+			r, ok := p.readHex4()
+			if !ok {
+				return "", p.errorf("invalid \\u escape")
+			}
+			// join surrogate pairs
+			if r >= 0xD800 && r < 0xDC00 && strings.HasPrefix(p.input[p.pos+1:], "\\u") {
+				p.pos += 2
+				low, ok := p.readHex4()
+				if !ok {
+					return "", p.errorf("invalid \\u escape")
+				}
+				r = (r-0xD800)<<10 + (low - 0xDC00) + 0x10000
+			}
+			out.WriteRune(r)
+		default:
+			return "", p.errorf("invalid escape \\%c", esc)
+		}
+		p.pos++
+	}
+
+	return "", p.errorf("unterminated string")
+}
+
+// readHex4 reads the four hex digits after \u, leaving pos on the last
+func (p *jsonParser) readHex4() (rune, bool) {
+	if p.pos+5 > len(p.input) {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(p.input[p.pos+1:p.pos+5], 16, 32)
+	if err != nil {
+		return 0, false
+	}
+	p.pos += 4
+	return rune(n), true
+}
+
+func (p *jsonParser) parseNumber() object.Object {
+	start := p.pos
+	isFloat := false
+
+	if p.input[p.pos] == '-' {
+		p.pos++
+	}
+	digits := p.pos
+	for p.pos < len(p.input) && isDigitByte(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos == digits {
+		return p.errorf("expected a digit, got %s", p.describe())
+	}
+	if p.pos < len(p.input) && p.input[p.pos] == '.' {
+		isFloat = true
+		p.pos++
+		for p.pos < len(p.input) && isDigitByte(p.input[p.pos]) {
+			p.pos++
+		}
+	}
+	if p.pos < len(p.input) && (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') {
+		isFloat = true
+		p.pos++
+		if p.pos < len(p.input) && (p.input[p.pos] == '+' || p.input[p.pos] == '-') {
+			p.pos++
+		}
+		for p.pos < len(p.input) && isDigitByte(p.input[p.pos]) {
+			p.pos++
+		}
+	}
+
+	text := p.input[start:p.pos]
+	if !isFloat {
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return &object.Integer{Value: n}
+		}
+		// too big for an integer, fall back to a float like most parsers
+	}
+	f, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		p.pos = start
+		return p.errorf("invalid number %s", text)
+	}
+	return &object.Float{Value: f}
+}
+
+func isDigitByte(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// jsonStringify encodes v, indenting nested values by indent spaces when
+// indent > 0
+func jsonStringify(v object.Object, indent int) object.Object {
+	var out strings.Builder
+	seen := make(map[object.Object]bool)
+	if err := writeJSON(&out, v, indent, 0, seen); err != nil {
+		return err
+	}
+	return &object.String{Value: out.String()}
+}
+
+func writeJSON(out *strings.Builder, v object.Object, indent, depth int, seen map[object.Object]bool) *object.Error {
+	switch v := v.(type) {
+	case *object.Null:
+		out.WriteString("null")
+	case *object.Boolean:
+		out.WriteString(strconv.FormatBool(v.Value))
+	case *object.Integer:
+		out.WriteString(strconv.FormatInt(v.Value, 10))
+	case *object.Float:
+		if math.IsNaN(v.Value) || math.IsInf(v.Value, 0) {
+			return newError("json_stringify(): cannot serialize %s", v.Inspect())
+		}
+		s := strconv.FormatFloat(v.Value, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			s += ".0"
+		}
+		out.WriteString(s)
+	case *object.String:
+		writeJSONString(out, v.Value)
+
+	case *object.Array:
+		if seen[v] {
+			return newError("json_stringify(): cannot serialize a cyclic structure")
+		}
+		seen[v] = true
+		defer delete(seen, v)
+
+		if len(v.Elements) == 0 {
+			out.WriteString("[]")
+			return nil
+		}
+		out.WriteString("[")
+		for i, el := range v.Elements {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			writeJSONNewline(out, indent, depth+1)
+			if err := writeJSON(out, el, indent, depth+1, seen); err != nil {
+				return err
+			}
+		}
+		writeJSONNewline(out, indent, depth)
+		out.WriteString("]")
+
+	case *object.Map, *object.Struct:
+		if seen[v] {
+			return newError("json_stringify(): cannot serialize a cyclic structure")
+		}
+		seen[v] = true
+		defer delete(seen, v)
+
+		pairs := jsonPairs(v)
+		if len(pairs) == 0 {
+			out.WriteString("{}")
+			return nil
+		}
+		out.WriteString("{")
+		for i, pair := range pairs {
+			if i > 0 {
+				out.WriteString(",")
+			}
+			writeJSONNewline(out, indent, depth+1)
+			// json keys are strings, so other key types use their text
+			writeJSONString(out, pair.Key.Inspect())
+			out.WriteString(":")
+			if indent > 0 {
+				out.WriteString(" ")
+			}
+			if err := writeJSON(out, pair.Value, indent, depth+1, seen); err != nil {
+				return err
+			}
+		}
+		writeJSONNewline(out, indent, depth)
+		out.WriteString("}")
+
+	default:
+		return newKindError(object.TYPE_ERROR, "json_stringify(): cannot serialize %s", jsonTypeName(v))
+	}
+	return nil
+}
+
+// jsonPairs lists the keys and values of a map, or the fields of a
+// struct, which is written as an object
+func jsonPairs(v object.Object) []object.MapPair {
+	if s, ok := v.(*object.Struct); ok {
+		return s.Pairs()
+	}
+	return v.(*object.Map).Ordered()
+}
+
+// jsonTypeName is the type named in serialization errors
+func jsonTypeName(v object.Object) string {
+	switch v := v.(type) {
+	case *object.Function, *object.Closure, *object.Builtin:
+		return "a function"
+	case *object.Regex:
+		return "a regex " + v.Inspect()
+	default:
+		return string(v.Type())
+	}
+}
+
+func writeJSONNewline(out *strings.Builder, indent, depth int) {
+	if indent > 0 {
+		out.WriteString("\n")
+		out.WriteString(strings.Repeat(" ", indent*depth))
+	}
+}
+
+func writeJSONString(out *strings.Builder, s string) {
+	out.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			out.WriteString(`\"`)
+		case '\\':
+			out.WriteString(`\\`)
+		case '\n':
+			out.WriteString(`\n`)
+		case '\r':
+			out.WriteString(`\r`)
+		case '\t':
+			out.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(out, `\u%04x`, r)
+			} else {
+				out.WriteRune(r)
+			}
+		}
+	}
+	out.WriteByte('"')
+}
@@ -0,0 +1,144 @@
+package evaluator
+
+import (
+	"os"
+	"path/filepath"
+	"pearl/ast"
+	"pearl/lexer"
+	"pearl/object"
+	"pearl/parser"
+	"strings"
+)
+
+var (
+	// modules caches every module by absolute path, so each file is
+	// evaluated once no matter how many scripts import it
+	modules = make(map[string]*object.Module)
+
+	// moduleFiles holds the display paths of loaded modules, used to tag
+	// errors raised inside them with the file name
+	moduleFiles = make(map[string]bool)
+
+	// importing is the chain of modules currently being evaluated
+	importing []string
+)
+
+func evalImportStatement(is *ast.ImportStatement, env *object.Environment) object.Object {
+	mod := loadModule(is.Path, env.File())
+	if isError(mod) {
+		return mod
+	}
+	m := mod.(*object.Module)
+
+	if is.Names == nil {
+		name := m.Name
+		if is.Alias != nil {
+			name = is.Alias.Value
+		}
+		env.Set(name, m)
+		return m
+	}
+
+	for _, n := range is.Names {
+		val, ok := m.Export(n.Name.Value)
+		if !ok {
+			return newKindError(object.NAME_ERROR, "module %s has no export %s", m.Name, n.Name.Value)
+		}
+		name := n.Name.Value
+		if n.Alias != nil {
+			name = n.Alias.Value
+		}
+		env.Set(name, val)
+	}
+	return NULL
+}
+
+func loadModule(path, from string) object.Object {
+	file, ok := findModule(path, from)
+	if !ok {
+		return newError("cannot find module %s", path)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return newError("cannot find module %s: %s", path, err)
+	}
+
+	chain := importing
+	if len(chain) == 0 && from != "" {
+		if fromAbs, err := filepath.Abs(from); err == nil {
+			chain = []string{fromAbs}
+		}
+	}
+	for i, p := range chain {
+		if p == abs {
+			cycle := []string{}
+			for _, c := range chain[i:] {
+				cycle = append(cycle, filepath.Base(c))
+			}
+			cycle = append(cycle, filepath.Base(abs))
+			return newError("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	if m, ok := modules[abs]; ok {
+		return m
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return newError("cannot read module %s: %s", path, err)
+	}
+
+	p := parser.New(lexer.New(string(data)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return newError("cannot parse module %s: %s", path, strings.Join(p.Errors(), "; "))
+	}
+
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	m := &object.Module{Name: name, Path: file, Env: object.NewEnvironment()}
+	m.Env.SetFile(file)
+	moduleFiles[file] = true
+
+	saved := importing
+	importing = append(chain[:len(chain):len(chain)], abs)
+	result := Eval(program, m.Env)
+	importing = saved
+
+	if isError(result) {
+		return result
+	}
+
+	modules[abs] = m
+	return m
+}
+
+// findModule resolves an import path against the importing file's
+// directory, then each directory in PEARL_PATH
+func findModule(path, from string) (string, bool) {
+	if filepath.IsAbs(path) {
+		return path, fileExists(path)
+	}
+
+	dirs := []string{"."}
+	if from != "" {
+		dirs[0] = filepath.Dir(from)
+	}
+	dirs = append(dirs, filepath.SplitList(os.Getenv("PEARL_PATH"))...)
+
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		file := filepath.Join(dir, path)
+		if fileExists(file) {
+			return file, true
+		}
+	}
+	return "", false
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
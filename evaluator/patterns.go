@@ -0,0 +1,110 @@
+package evaluator
+
+import (
+	"pearl/ast"
+	"pearl/object"
+)
+
+// bindPattern binds the names in a let, for or parameter pattern to the
+// matching parts of val
+func bindPattern(pattern ast.Expression, val object.Object, env *object.Environment) *object.Error {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		env.Set(pattern.Value, val)
+
+	case *ast.ArrayPattern:
+		vals, err := destructureArray(val, len(pattern.Elements), pattern.Rest != nil)
+		if err != nil {
+			return err
+		}
+		for i, el := range pattern.Elements {
+			if err := bindPattern(el, vals[i], env); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			env.Set(pattern.Rest.Value, vals[len(pattern.Elements)])
+		}
+
+	case *ast.MapPattern:
+		vals, err := destructureMap(val, pattern.Keys, pattern.Rest != nil)
+		if err != nil {
+			return err
+		}
+		for i, v := range pattern.Values {
+			if err := bindPattern(v, vals[i], env); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			env.Set(pattern.Rest.Value, vals[len(pattern.Keys)])
+		}
+	}
+	return nil
+}
+
+// destructureArray takes the first n elements of an array, with null for
+// any that are missing like an out of range index, followed by the
+// remaining elements as a new array when rest is set
+func destructureArray(val object.Object, n int, rest bool) ([]object.Object, *object.Error) {
+	arr, ok := val.(*object.Array)
+	if !ok {
+		return nil, newKindError(object.TYPE_ERROR, "cannot destructure %s with an array pattern", typeName(val))
+	}
+
+	vals := make([]object.Object, n, n+1)
+	for i := range vals {
+		if i < len(arr.Elements) {
+			vals[i] = arr.Elements[i]
+		} else {
+			vals[i] = NULL
+		}
+	}
+	if rest {
+		remaining := []object.Object{}
+		if n < len(arr.Elements) {
+			remaining = append(remaining, arr.Elements[n:]...)
+		}
+		vals = append(vals, &object.Array{Elements: remaining})
+	}
+	return vals, nil
+}
+
+// destructureMap reads keys from a map or fields from a struct the way
+// member access does, followed by a map of everything else when rest is set
+func destructureMap(val object.Object, keys []string, rest bool) ([]object.Object, *object.Error) {
+	var pairs []object.MapPair
+	switch val := val.(type) {
+	case *object.Map:
+		pairs = val.Ordered()
+	case *object.Struct:
+		pairs = val.Pairs()
+	default:
+		return nil, newKindError(object.TYPE_ERROR, "cannot destructure %s with a map pattern", typeName(val))
+	}
+
+	vals := make([]object.Object, len(keys), len(keys)+1)
+	for i, key := range keys {
+		v := memberValue(val, key)
+		if isError(v) {
+			return nil, v.(*object.Error)
+		}
+		vals[i] = v
+	}
+
+	if rest {
+		taken := make(map[string]bool)
+		for _, key := range keys {
+			taken[key] = true
+		}
+		m := object.NewMap()
+		for _, pair := range pairs {
+			if s, ok := pair.Key.(*object.String); ok && taken[s.Value] {
+				continue
+			}
+			m.Set(pair.Key, pair.Value)
+		}
+		vals = append(vals, m)
+	}
+	return vals, nil
+}
@@ -0,0 +1,191 @@
+package evaluator
+
+import (
+	"pearl/object"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// matchResult is what match() and match_all() return for one match: an
+// array of the whole match and each capture, or when the regex has named
+// captures, a map holding them under their names as well as their numbers
+func matchResult(re *regexp.Regexp, groups []string) object.Object {
+	named := false
+	for _, name := range re.SubexpNames() {
+		if name != "" {
+			named = true
+		}
+	}
+
+	if !named {
+		elements := make([]object.Object, len(groups))
+		for i, g := range groups {
+			elements[i] = &object.String{Value: g}
+		}
+		return &object.Array{Elements: elements}
+	}
+
+	m := object.NewMap()
+	for i, g := range groups {
+		m.Set(&object.Integer{Value: int64(i)}, &object.String{Value: g})
+	}
+	for i, name := range re.SubexpNames() {
+		if name != "" {
+			m.Set(&object.String{Value: name}, &object.String{Value: groups[i]})
+		}
+	}
+	return m
+}
+
+// substitute replaces the first match of re in s, or every match when all
+// is set. repl is either a template where $1, $name and ${name} stand for
+// captures and $$ for a dollar sign, or a function called with the match array that
+// returns the replacement.
+func substitute(name, s string, re *regexp.Regexp, repl object.Object, all bool) object.Object {
+	template, isTemplate := repl.(*object.String)
+	if !isTemplate && !isCallable(repl) {
+		return newError("%s replacement must be a string or function", name)
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		out.WriteString(s[last:loc[0]])
+		last = loc[1]
+
+		groups := make([]string, len(loc)/2)
+		for i := range groups {
+			if loc[2*i] >= 0 {
+				groups[i] = s[loc[2*i]:loc[2*i+1]]
+			}
+		}
+
+		if isTemplate {
+			text, err := expandTemplate(name, template.Value, re, groups)
+			if err != nil {
+				return err
+			}
+			out.WriteString(text)
+		} else {
+			elements := make([]object.Object, len(groups))
+			for i, g := range groups {
+				elements[i] = &object.String{Value: g}
+			}
+			result := callCallback(repl, []object.Object{&object.Array{Elements: elements}})
+			if isError(result) {
+				return result
+			}
+			if str, ok := result.(*object.String); ok {
+				out.WriteString(str.Value)
+			} else {
+				out.WriteString(result.Inspect())
+			}
+		}
+
+		if !all {
+			break
+		}
+	}
+	out.WriteString(s[last:])
+	return &object.String{Value: out.String()}
+}
+
+// expandTemplate fills in the $1, ${1}, $name, ${name} and $$ in a
+// replacement. $name is only a group when the regex has one of that name,
+// since a string literal can't hold ${name} without escaping the brace.
+func expandTemplate(name, template string, re *regexp.Regexp, groups []string) (string, *object.Error) {
+	var out strings.Builder
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		if c != '$' || i+1 == len(template) {
+			out.WriteByte(c)
+			continue
+		}
+
+		var ref string
+		switch next := template[i+1]; {
+		case next == '$':
+			out.WriteByte('$')
+			i++
+			continue
+		case next == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end < 0 {
+				return "", newError("%s replacement has an unclosed ${", name)
+			}
+			ref = template[i+2 : i+end]
+			i += end
+		case next >= '0' && next <= '9':
+			j := i + 1
+			for j < len(template) && template[j] >= '0' && template[j] <= '9' {
+				j++
+			}
+			ref = template[i+1 : j]
+			i = j - 1
+		case isWordByte(next):
+			j := i + 1
+			for j < len(template) && isWordByte(template[j]) {
+				j++
+			}
+			if re.SubexpIndex(template[i+1:j]) < 0 {
+				out.WriteByte(c)
+				continue
+			}
+			ref = template[i+1 : j]
+			i = j - 1
+		default:
+			out.WriteByte(c)
+			continue
+		}
+
+		idx, err := strconv.Atoi(ref)
+		if err != nil {
+			idx = re.SubexpIndex(ref)
+		}
+		if idx < 0 || idx >= len(groups) {
+			return "", newError("%s replacement refers to $%s, but the regex has no such group", name, ref)
+		}
+		out.WriteString(groups[idx])
+	}
+	return out.String(), nil
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// evalRewriteExpression applies s/// or tr/// to a string with ~
+func evalRewriteExpression(operator string, left, right object.Object) object.Object {
+	if operator != "~" {
+		return newKindError(object.TYPE_ERROR, "unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
+	str := left.(*object.String).Value
+	switch r := right.(type) {
+	case *object.Substitution:
+		return substitute("s///", str, r.Regex.Regexp, &object.String{Value: r.Replacement}, r.Global)
+	case *object.Transliteration:
+		return &object.String{Value: r.Apply(str)}
+	}
+	return NULL
+}
+
+// substituteArgs checks the arguments of sub() and gsub(). A string
+// pattern matches literally, the same as in replace().
+func substituteArgs(name string, args []object.Object) (string, *regexp.Regexp, *object.Error) {
+	if len(args) != 3 {
+		return "", nil, newError("%s() takes 3 arguments: string, regex, replacement", name)
+	}
+	s, ok := args[0].(*object.String)
+	if !ok {
+		return "", nil, newError("%s() first arg must be a string", name)
+	}
+	switch pattern := args[1].(type) {
+	case *object.Regex:
+		return s.Value, pattern.Regexp, nil
+	case *object.String:
+		return s.Value, regexp.MustCompile(regexp.QuoteMeta(pattern.Value)), nil
+	default:
+		return "", nil, newError("%s() second arg must be a regex or string", name)
+	}
+}
@@ -0,0 +1,150 @@
+package evaluator
+
+import (
+	"pearl/object"
+)
+
+// The functions below let the vm package run programs with exactly the
+// evaluator's semantics, so both backends agree on every operator,
+// conversion and error message.
+
+func InfixOp(operator string, left, right object.Object) object.Object {
+	return evalInfixExpression(operator, left, right)
+}
+
+func PrefixOp(operator string, right object.Object) object.Object {
+	return evalPrefixExpression(operator, right)
+}
+
+func IndexOp(left, index object.Object) object.Object {
+	return Hold(evalIndexExpression(left, index))
+}
+
+func SetIndex(left, index, val object.Object) object.Object {
+	return assignIndex(left, index, val)
+}
+
+func GetMember(obj object.Object, name string) object.Object {
+	return Hold(memberValue(obj, name))
+}
+
+func SetMember(obj object.Object, name string, val object.Object) object.Object {
+	return assignMember(obj, name, val)
+}
+
+// MethodCallee is the field recv.name(...) calls, if name is a field
+// rather than a method
+func MethodCallee(recv object.Object, name string) (object.Object, bool) {
+	return memberCallee(recv, name)
+}
+
+// TypeName is what type() reports for obj
+func TypeName(obj object.Object) string {
+	return typeName(obj)
+}
+
+// StructMethod finds a method declared on recv's struct
+func StructMethod(recv object.Object, name string) (object.Object, bool) {
+	return structMethod(recv, name)
+}
+
+func DefineMethod(recv object.Object, name string, fn object.Object) object.Object {
+	return defineMethod(recv, name, fn)
+}
+
+// NewStruct builds an instance from the field values its constructor
+// returned
+func NewStruct(def *object.StructType, values object.Object) object.Object {
+	return newStruct(def, values)
+}
+
+// DestructureArray splits val for an array pattern with n elements
+func DestructureArray(val object.Object, n int, rest bool) ([]object.Object, *object.Error) {
+	return destructureArray(val, n, rest)
+}
+
+// DestructureMap splits val for a map pattern with the given keys
+func DestructureMap(val object.Object, keys []string, rest bool) ([]object.Object, *object.Error) {
+	return destructureMap(val, keys, rest)
+}
+
+// MakeRange builds a range for OpRange; step is nil when there isn't one
+func MakeRange(start, end, step object.Object, inclusive bool) object.Object {
+	return newRange(start, end, step, inclusive)
+}
+
+// MakeMap builds a map from parallel key and value slices
+func MakeMap(keys, values []object.Object) object.Object {
+	m := object.NewMap()
+	for i, key := range keys {
+		if _, ok := key.(object.Hashable); !ok {
+			return newError("unusable as map key: %s", key.Type())
+		}
+		m.Set(key, values[i])
+	}
+	return m
+}
+
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// Equal is the value equality used by match arms
+func Equal(a, b object.Object) bool {
+	return objectsEqual(a, b)
+}
+
+// ErrorValue is what a catch block sees for err
+func ErrorValue(e *object.Error) object.Object {
+	return errorToMap(e)
+}
+
+// Import loads the module at path for the script at from, as an import
+// statement does
+func Import(path, from string) object.Object {
+	return loadModule(path, from)
+}
+
+// Apply calls a function the evaluator made, like a module's export
+func Apply(fn object.Object, args []object.Object, names []string) object.Object {
+	return applyFunction(fn, args, names)
+}
+
+// SpreadArgs expands ...arr and **opts arguments
+func SpreadArgs(args []object.Object, names []string) ([]object.Object, []string, *object.Error) {
+	return spreadArgs(args, names)
+}
+
+// BindArgs matches arguments to a user function's parameters
+func BindArgs(fnName string, params []string, variadic bool, args []object.Object, names []string) ([]object.Object, *object.Error) {
+	return bindArgs(fnName, params, variadic, args, names)
+}
+
+// BindBuiltinArgs places named arguments for a builtin call
+func BindBuiltinArgs(b *object.Builtin, args []object.Object, names []string) ([]object.Object, *object.Error) {
+	return bindBuiltinArgs(b, args, names)
+}
+
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	b, ok := builtins[name]
+	return b, ok
+}
+
+// Iterable is what a for loop over obj actually walks
+func Iterable(obj object.Object) object.Object {
+	return iterableOf(obj)
+}
+
+// IOError reports a failed file operation the way the builtins do
+func IOError(what, path string, err error) *object.Error {
+	return ioError(what, path, err)
+}
+
+// FormatValue formats val for an interpolation like {price:.2f}
+func FormatValue(val object.Object, spec string) object.Object {
+	s, err := formatValue(val, spec)
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: s}
+}
@@ -0,0 +1,205 @@
+package evaluator
+
+import (
+	"cmp"
+	"pearl/object"
+	"sort"
+	"strings"
+)
+
+// compareValues orders two values for sort(). Values of different types
+// go by type: null, booleans, numbers, strings, arrays, then the rest by
+// type name. Within a type numbers go by value, strings by character,
+// booleans false first and arrays element by element with a shorter
+// prefix first. Other values, like maps, go by how they print, so every
+// list of values has an order.
+func compareValues(a, b object.Object) int {
+	if c := cmp.Compare(typeRank(a), typeRank(b)); c != 0 {
+		return c
+	}
+
+	switch a := a.(type) {
+	case *object.Integer:
+		switch b := b.(type) {
+		case *object.Integer:
+			return cmp.Compare(a.Value, b.Value)
+		case *object.Float:
+			return cmp.Compare(float64(a.Value), b.Value)
+		}
+	case *object.Float:
+		switch b := b.(type) {
+		case *object.Integer:
+			return cmp.Compare(a.Value, float64(b.Value))
+		case *object.Float:
+			return cmp.Compare(a.Value, b.Value)
+		}
+	case *object.String:
+		return strings.Compare(a.Value, b.(*object.String).Value)
+	case *object.Boolean:
+		b := b.(*object.Boolean)
+		switch {
+		case a.Value == b.Value:
+			return 0
+		case b.Value:
+			return -1
+		}
+		return 1
+	case *object.Null:
+		return 0
+	case *object.Array:
+		b := b.(*object.Array)
+		for i := 0; i < len(a.Elements) && i < len(b.Elements); i++ {
+			if c := compareValues(a.Elements[i], b.Elements[i]); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(len(a.Elements), len(b.Elements))
+	}
+
+	if c := strings.Compare(typeName(a), typeName(b)); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Inspect(), b.Inspect())
+}
+
+// typeRank is where values of a type sort among other types
+func typeRank(obj object.Object) int {
+	switch obj.(type) {
+	case *object.Null:
+		return 0
+	case *object.Boolean:
+		return 1
+	case *object.Integer, *object.Float:
+		return 2
+	case *object.String:
+		return 3
+	case *object.Array:
+		return 4
+	}
+	return 5
+}
+
+// sortValues sorts elements in place, keeping equal ones in the order they
+// came. by, when not null, gives each element's key, called once per
+// element. cmp, when not null, is called with two values and returns a
+// negative number, zero or a positive number. reverse sorts largest first.
+func sortValues(name string, elements []object.Object, by, cmpFn object.Object, reverse bool) *object.Error {
+	keys := elements
+	if by != NULL {
+		keys = make([]object.Object, len(elements))
+		for i, el := range elements {
+			key := callCallback(by, []object.Object{el})
+			if isError(key) {
+				return key.(*object.Error)
+			}
+			keys[i] = key
+		}
+	}
+
+	compare := func(a, b object.Object) (int, *object.Error) {
+		return compareValues(a, b), nil
+	}
+	if cmpFn != NULL {
+		compare = func(a, b object.Object) (int, *object.Error) {
+			result := callCallback(cmpFn, []object.Object{a, b})
+			switch r := result.(type) {
+			case *object.Error:
+				return 0, r
+			case *object.Integer:
+				return cmp.Compare(r.Value, 0), nil
+			case *object.Float:
+				return cmp.Compare(r.Value, 0), nil
+			}
+			return 0, newKindError(object.TYPE_ERROR, "%s() cmp must return a number, got %s", name, typeName(result))
+		}
+	}
+
+	// sort positions so elements and their keys move together
+	order := make([]int, len(elements))
+	for i := range order {
+		order[i] = i
+	}
+	var failed *object.Error
+	sort.SliceStable(order, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+		c, err := compare(keys[order[i]], keys[order[j]])
+		if err != nil {
+			failed = err
+			return false
+		}
+		if reverse {
+			return c > 0
+		}
+		return c < 0
+	})
+	if failed != nil {
+		return failed
+	}
+
+	moved := make([]object.Object, len(elements))
+	for i, idx := range order {
+		moved[i] = elements[idx]
+	}
+	copy(elements, moved)
+	return nil
+}
+
+// sorted returns a sorted array of the values of an array or other
+// iterable, leaving an array argument as it was
+func sorted(name string, values, by, cmpFn object.Object, reverse bool) object.Object {
+	next, err := iterArg(name, values)
+	if err != nil {
+		return err
+	}
+	arr := collect(next)
+	if isError(arr) {
+		return arr
+	}
+	if err := sortValues(name, arr.(*object.Array).Elements, by, cmpFn, reverse); err != nil {
+		return err
+	}
+	return arr
+}
+
+// extremeByArgs checks the arguments of min_by() and max_by()
+func extremeByArgs(name string, args []object.Object, largest bool) object.Object {
+	if len(args) != 2 {
+		return newError("%s() takes 2 arguments: values, fn", name)
+	}
+	if !isCallable(args[1]) {
+		return newError("%s() second arg must be a function", name)
+	}
+	next, err := iterArg(name, args[0])
+	if err != nil {
+		return err
+	}
+	return extremeBy(next, args[1], largest)
+}
+
+// extremeBy is min_by() and max_by(): the first value whose key from fn is
+// smallest, or largest, or null when there are no values
+func extremeBy(next func() (object.Object, bool), fn object.Object, largest bool) object.Object {
+	var best, bestKey object.Object = NULL, nil
+	for {
+		val, ok := next()
+		if !ok {
+			return best
+		}
+		if isError(val) {
+			return val
+		}
+		key := callCallback(fn, []object.Object{val})
+		if isError(key) {
+			return key
+		}
+		if bestKey != nil {
+			c := compareValues(key, bestKey)
+			if largest && c <= 0 || !largest && c >= 0 {
+				continue
+			}
+		}
+		best, bestKey = val, key
+	}
+}
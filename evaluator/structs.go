@@ -0,0 +1,71 @@
+package evaluator
+
+import (
+	"pearl/ast"
+	"pearl/object"
+)
+
+func evalStructStatement(ss *ast.StructStatement, env *object.Environment) object.Object {
+	init := &object.Function{Parameters: ss.Init.Parameters, Body: ss.Init.Body, Env: env, Name: ss.Name.Value}
+	def := newStructType(ss.Name.Value, ss.Fields, init)
+	env.Set(def.Name, def)
+	return def
+}
+
+func newStructType(name string, fields []*ast.FunctionParam, init object.Object) *object.StructType {
+	def := &object.StructType{Name: name, Init: init, Methods: make(map[string]object.Object)}
+	for _, f := range fields {
+		def.Fields = append(def.Fields, f.Name.Value)
+	}
+	return def
+}
+
+// newStruct wraps the field values returned by def.Init in an instance
+func newStruct(def *object.StructType, values object.Object) object.Object {
+	if isError(values) {
+		return values
+	}
+	return &object.Struct{Def: def, Values: values.(*object.Array).Elements}
+}
+
+// evalMethodLiteral handles fn Point.dist(self, other) { ... }, adding the
+// method to the struct rather than binding a variable
+func evalMethodLiteral(fl *ast.FunctionLiteral, env *object.Environment) object.Object {
+	recv := evalIdentifier(fl.Receiver, env)
+	if isError(recv) {
+		return recv
+	}
+	fn := &object.Function{Parameters: fl.Parameters, Body: fl.Body, Env: env, Name: fl.Receiver.Value + "." + fl.Name, Generator: fl.Generator}
+	return defineMethod(recv, fl.Name, fn)
+}
+
+func defineMethod(recv object.Object, name string, fn object.Object) object.Object {
+	def, ok := recv.(*object.StructType)
+	if !ok {
+		return newKindError(object.TYPE_ERROR, "cannot add method %s to %s, methods need a struct", name, recv.Type())
+	}
+	for _, f := range def.Fields {
+		if f == name {
+			return newError("cannot add method %s to %s, it has a field with that name", name, def.Name)
+		}
+	}
+	def.Methods[name] = fn
+	return fn
+}
+
+// typeName is what type() reports: a struct's own name, otherwise the
+// object type
+func typeName(obj object.Object) string {
+	if s, ok := obj.(*object.Struct); ok {
+		return s.Def.Name
+	}
+	return string(obj.Type())
+}
+
+// structMethod finds a method declared on recv's struct
+func structMethod(recv object.Object, name string) (object.Object, bool) {
+	if s, ok := recv.(*object.Struct); ok {
+		return s.Def.Method(name)
+	}
+	return nil, false
+}
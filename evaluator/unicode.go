@@ -0,0 +1,104 @@
+package evaluator
+
+import (
+	"pearl/object"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Strings are indexed, measured and cut by code point. The builtins that
+// take graphemes = true work on grapheme clusters instead, what a reader
+// sees as one character: "e" plus a combining accent, or a family emoji
+// built from several code points.
+
+const zeroWidthJoiner = '\u200d'
+
+// graphemes splits s into grapheme clusters. It follows the main rules of
+// Unicode's segmentation (UAX #29): \r\n stays together, combining marks,
+// variation selectors, skin tones and emoji tags stay with what they
+// modify, a zero-width joiner joins the characters around it, and regional
+// indicators pair up into flags. Hangul jamo and prepended marks split as
+// separate code points.
+func graphemes(s string) []string {
+	clusters := []string{}
+	start := 0
+	prev := rune(-1)
+	regional := 0 // regional indicators in a row, up to prev
+
+	for i, r := range s {
+		if prev >= 0 && !joinsCluster(prev, r, regional) {
+			clusters = append(clusters, s[start:i])
+			start = i
+		}
+		if isRegionalIndicator(r) {
+			regional++
+		} else {
+			regional = 0
+		}
+		prev = r
+	}
+	if start < len(s) {
+		clusters = append(clusters, s[start:])
+	}
+	return clusters
+}
+
+// joinsCluster reports whether r continues the cluster ending in prev
+func joinsCluster(prev, r rune, regional int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case prev == '\r' || prev == '\n' || r == '\r' || r == '\n':
+		return false
+	case r == zeroWidthJoiner || isGraphemeExtend(r):
+		return true
+	case prev == zeroWidthJoiner:
+		return true
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return regional%2 == 1
+	}
+	return false
+}
+
+func isGraphemeExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc, unicode.Variation_Selector) ||
+		r >= 0x1f3fb && r <= 0x1f3ff || // skin tone modifiers
+		r >= 0xe0020 && r <= 0xe007f // emoji tag characters
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// textUnits splits s into code points, or grapheme clusters
+func textUnits(s string, clusters bool) []string {
+	if clusters {
+		return graphemes(s)
+	}
+	units := make([]string, 0, len(s))
+	for _, r := range s {
+		units = append(units, string(r))
+	}
+	return units
+}
+
+// graphemesArg reads a builtin's graphemes option, which is null when it
+// wasn't given
+func graphemesArg(name string, args []object.Object, i int) (bool, *object.Error) {
+	if i >= len(args) || args[i] == NULL {
+		return false, nil
+	}
+	b, ok := args[i].(*object.Boolean)
+	if !ok {
+		return false, newError("%s() graphemes must be true or false", name)
+	}
+	return b.Value, nil
+}
+
+// runeIndex converts a byte offset in s to a code point index
+func runeIndex(s string, byteIdx int) int {
+	if byteIdx < 0 {
+		return byteIdx
+	}
+	return utf8.RuneCountInString(s[:byteIdx])
+}
@@ -0,0 +1,107 @@
+package evaluator
+
+import (
+	"pearl/lexer"
+	"pearl/object"
+	"pearl/parser"
+	"testing"
+)
+
+// TestUnicodeStrings checks the string builtins and indexing count code
+// points, or grapheme clusters with graphemes = true, on text where the
+// two differ from bytes and from each other
+func TestUnicodeStrings(t *testing.T) {
+	const (
+		family = "\U0001f468\u200d\U0001f469\u200d\U0001f467" // man, woman, girl joined
+		japan  = "\U0001f1ef\U0001f1f5"                       // regional indicators J P
+		france = "\U0001f1eb\U0001f1f7"                       // regional indicators F R
+	)
+	type check struct {
+		expr string // run with s bound to the input
+		want string // Inspect of the result
+	}
+	tests := []struct {
+		name   string
+		s      string
+		checks []check
+	}{
+		{"precomposed accent", "caf\u00e9", []check{
+			{"len(s)", "4"},
+			{"len(s, graphemes = true)", "4"},
+			{"s[3]", "\u00e9"},
+			{"s[-1]", "\u00e9"},
+			{"substr(s, 2)", "f\u00e9"},
+			{"substr(s, 2, 1, graphemes = true)", "f"},
+			{"find(s, \"\u00e9\")", "3"},
+			{"reverse(s)", "\u00e9fac"},
+			{"reverse(s, graphemes = true)", "\u00e9fac"},
+			{"graphemes(s)", "[c, a, f, \u00e9]"},
+		}},
+		{"combining accent", "cafe\u0301", []check{
+			{"len(s)", "5"},
+			{"len(s, graphemes = true)", "4"},
+			{"s[3]", "e"},
+			{"s[4]", "\u0301"},
+			{"substr(s, 3)", "e\u0301"},
+			{"substr(s, 3, 1)", "e"},
+			{"substr(s, 3, 1, graphemes = true)", "e\u0301"},
+			{"find(s, \"\u0301\")", "4"},
+			{"reverse(s)", "\u0301efac"},
+			{"reverse(s, graphemes = true)", "e\u0301fac"},
+			{"graphemes(s)", "[c, a, f, e\u0301]"},
+		}},
+		{"CJK", "日本語です", []check{
+			{"len(s)", "5"},
+			{"len(s, graphemes = true)", "5"},
+			{"s[1]", "本"},
+			{"s[-2]", "で"},
+			{"substr(s, 1, 2)", "本語"},
+			{"substr(s, 1, 2, graphemes = true)", "本語"},
+			{"find(s, \"です\")", "3"},
+			{"find(s, \"x\")", "-1"},
+			{"reverse(s)", "すで語本日"},
+			{"graphemes(s)", "[日, 本, 語, で, す]"},
+		}},
+		{"emoji with zero width joiners", "a" + family + "b", []check{
+			{"len(s)", "7"},
+			{"len(s, graphemes = true)", "3"},
+			{"s[1]", "\U0001f468"},
+			{"s[2]", "\u200d"},
+			{"substr(s, 1, 5)", family},
+			{"substr(s, 1, 1, graphemes = true)", family},
+			{"find(s, \"b\")", "6"},
+			{"reverse(s)", "b\U0001f467\u200d\U0001f469\u200d\U0001f468a"},
+			{"reverse(s, graphemes = true)", "b" + family + "a"},
+			{"graphemes(s)", "[a, " + family + ", b]"},
+		}},
+		{"flags", japan + france, []check{
+			{"len(s)", "4"},
+			{"len(s, graphemes = true)", "2"},
+			{"s[2]", "\U0001f1eb"},
+			{"substr(s, 2)", france},
+			{"substr(s, 1, 1, graphemes = true)", france},
+			{"find(s, \"" + france + "\")", "2"},
+			{"reverse(s)", "\U0001f1f7\U0001f1eb\U0001f1f5\U0001f1ef"},
+			{"reverse(s, graphemes = true)", france + japan},
+			{"graphemes(s)", "[" + japan + ", " + france + "]"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range tt.checks {
+				p := parser.New(lexer.New(c.expr))
+				program := p.ParseProgram()
+				if len(p.Errors()) != 0 {
+					t.Fatalf("%s: parse errors %v", c.expr, p.Errors())
+				}
+				env := object.NewEnvironment()
+				env.Set("s", &object.String{Value: tt.s})
+				got := Eval(program, env)
+				if got == nil || isError(got) || got.Inspect() != c.want {
+					t.Errorf("%s: got %v, want %q", c.expr, got, c.want)
+				}
+			}
+		})
+	}
+}
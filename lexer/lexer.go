@@ -3,6 +3,8 @@ package lexer
 import (
 	"fmt"
 	"pearl/token"
+	"strings"
+	"unicode/utf8"
 )
 
 type Lexer struct {
@@ -12,10 +14,21 @@ type Lexer struct {
 	ch      byte // current char
 	line    int
 	col     int
+
+	// strings is where each string literal's opening quote is in the
+	// input, by its line and column, for StringPos
+	strings map[[2]int]int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input, line: 1, col: 0}
+	return NewAt(input, 1, 1)
+}
+
+// NewAt is New for input that starts at line and col of a bigger source,
+// like an expression inside a string, so its tokens have the positions
+// they have there
+func NewAt(input string, line, col int) *Lexer {
+	l := &Lexer{input: input, line: line, col: col - 1}
 	l.readChar()
 	return l
 }
@@ -28,7 +41,10 @@ func (l *Lexer) readChar() {
 	}
 	l.pos = l.readPos
 	l.readPos++
-	l.col++
+	// columns count characters, so skip the later bytes of multi-byte ones
+	if l.ch&0xC0 != 0x80 {
+		l.col++
+	}
 
 	if l.ch == '\n' {
 		l.line++
@@ -36,6 +52,30 @@ func (l *Lexer) readChar() {
 	}
 }
 
+// Mark is a saved lexer position, see Mark and Reset
+type Mark struct {
+	pos     int
+	readPos int
+	ch      byte
+	line    int
+	col     int
+}
+
+// Mark records the current position so the parser can re-read raw input
+// (regex literals) from a token it has already lexed
+func (l *Lexer) Mark() Mark {
+	return Mark{pos: l.pos, readPos: l.readPos, ch: l.ch, line: l.line, col: l.col}
+}
+
+// Reset rewinds the lexer to a position returned by Mark
+func (l *Lexer) Reset(m Mark) {
+	l.pos = m.pos
+	l.readPos = m.readPos
+	l.ch = m.ch
+	l.line = m.line
+	l.col = m.col
+}
+
 func (l *Lexer) peekChar() byte {
 	if l.readPos >= len(l.input) {
 		return 0
@@ -43,13 +83,25 @@ func (l *Lexer) peekChar() byte {
 	return l.input[l.readPos]
 }
 
+// NextToken returns the next token, positioned at its first character
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+	for {
+		l.skipWhitespace()
+		if l.ch != '#' {
+			break
+		}
+		l.skipComment()
+	}
 
-	l.skipWhitespace()
+	line, col := l.line, l.col
+	tok := l.readToken()
+	tok.Line = line
+	tok.Col = col
+	return tok
+}
 
-	tok.Line = l.line
-	tok.Col = l.col
+func (l *Lexer) readToken() token.Token {
+	var tok token.Token
 
 	switch l.ch {
 	case '=':
@@ -106,13 +158,26 @@ func (l *Lexer) NextToken() token.Token {
 			tok = l.newToken(token.GT, l.ch)
 		}
 	case '~':
-		tok = l.newToken(token.MATCH, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.MATCH_ASSIGN, Literal: "~=", Line: l.line, Col: l.col}
+		} else {
+			tok = l.newToken(token.MATCH, l.ch)
+		}
 	case '.':
 		if l.peekChar() == '.' {
 			l.readChar()
-			tok = token.Token{Type: token.RANGE, Literal: "..", Line: l.line, Col: l.col}
+			if l.peekChar() == '.' {
+				l.readChar()
+				tok = token.Token{Type: token.ELLIPSIS, Literal: "...", Line: l.line, Col: l.col}
+			} else if l.peekChar() == '=' {
+				l.readChar()
+				tok = token.Token{Type: token.RANGE_INCL, Literal: "..=", Line: l.line, Col: l.col}
+			} else {
+				tok = token.Token{Type: token.RANGE, Literal: "..", Line: l.line, Col: l.col}
+			}
 		} else {
-			tok = l.newToken(token.ILLEGAL, l.ch)
+			tok = l.newToken(token.DOT, l.ch)
 		}
 	case '|':
 		if l.peekChar() == '>' {
@@ -145,9 +210,6 @@ func (l *Lexer) NextToken() token.Token {
 		tok.Line = l.line
 		tok.Col = l.col
 		return tok
-	case '#':
-		l.skipComment()
-		return l.NextToken()
 	case '\n':
 		tok = l.newToken(token.NEWLINE, l.ch)
 	case 0:
@@ -171,6 +233,13 @@ func (l *Lexer) NextToken() token.Token {
 				tok.Type = token.INT
 			}
 			return tok
+		} else if l.ch >= utf8.RuneSelf {
+			// keep a stray multi-byte character whole for the error
+			_, size := utf8.DecodeRuneInString(l.input[l.pos:])
+			tok = token.Token{Type: token.ILLEGAL, Literal: l.input[l.pos : l.pos+size], Line: l.line, Col: l.col}
+			for i := 1; i < size; i++ {
+				l.readChar()
+			}
 		} else {
 			tok = l.newToken(token.ILLEGAL, l.ch)
 		}
@@ -207,8 +276,20 @@ func (l *Lexer) readNumber() (string, bool) {
 	return l.input[pos:l.pos], isFloat
 }
 
+// EscapedBrace stands in for \{ in a string token, so the parser can tell
+// it apart from a { that starts an interpolation. Strings can't contain it
+// otherwise, since a NUL byte ends the input.
+const EscapedBrace = "\x00{"
+
+// readString reads a string literal. It copies bytes as they are, so
+// multi-byte UTF-8 characters come through whole.
 func (l *Lexer) readString() string {
-	var result string
+	if l.strings == nil {
+		l.strings = make(map[[2]int]int)
+	}
+	l.strings[[2]int{l.line, l.col}] = l.pos
+
+	var result strings.Builder
 	l.readChar() // skip opening quote
 
 	for l.ch != '"' && l.ch != 0 {
@@ -216,22 +297,23 @@ func (l *Lexer) readString() string {
 			l.readChar()
 			switch l.ch {
 			case 'n':
-				result += "\n"
+				result.WriteByte('\n')
 			case 't':
-				result += "\t"
+				result.WriteByte('\t')
 			case 'r':
-				result += "\r"
+				result.WriteByte('\r')
 			case '"':
-				result += "\""
+				result.WriteByte('"')
 			case '\\':
-				result += "\\"
+				result.WriteByte('\\')
 			case '{':
-				result += "{"
+				result.WriteString(EscapedBrace)
 			default:
-				result += "\\" + string(l.ch)
+				result.WriteByte('\\')
+				result.WriteByte(l.ch)
 			}
 		} else {
-			result += string(l.ch)
+			result.WriteByte(l.ch)
 		}
 		l.readChar()
 	}
@@ -241,7 +323,32 @@ func (l *Lexer) readString() string {
 		l.readChar()
 	}
 
-	return result
+	return result.String()
+}
+
+// StringPos is the line and column in the source of byte i of the string
+// literal read at line and col, counting each escape as the characters it
+// was written with
+func (l *Lexer) StringPos(line, col, i int) (int, int) {
+	pos, ok := l.strings[[2]int{line, col}]
+	if !ok {
+		return line, col
+	}
+	s := &Lexer{input: l.input, readPos: pos, line: line, col: col - 1}
+	s.readChar()
+	s.readChar() // skip opening quote
+	for n := 0; n < i && s.ch != 0; n++ {
+		if s.ch == '\\' {
+			s.readChar()
+			switch s.ch {
+			case 'n', 't', 'r', '"', '\\':
+			default:
+				n++ // written as two bytes of the literal
+			}
+		}
+		s.readChar()
+	}
+	return s.line, s.col
 }
 
 // ReadRegexFromStart reads a regex when we haven't yet tokenized the opening /
@@ -257,16 +364,16 @@ func (l *Lexer) ReadRegexFromStart() (string, error) {
 	}
 	l.readChar() // skip opening /
 
-	var result string
+	var result strings.Builder
 	for l.ch != '/' && l.ch != 0 && l.ch != '\n' {
 		if l.ch == '\\' {
-			result += string(l.ch)
+			result.WriteByte(l.ch)
 			l.readChar()
 			if l.ch != 0 {
-				result += string(l.ch)
+				result.WriteByte(l.ch)
 			}
 		} else {
-			result += string(l.ch)
+			result.WriteByte(l.ch)
 		}
 		l.readChar()
 	}
@@ -276,34 +383,101 @@ func (l *Lexer) ReadRegexFromStart() (string, error) {
 	}
 	l.readChar() // skip closing /
 
-	return result, nil
+	return result.String(), nil
 }
 
-// ReadRegex reads a regex pattern. Called when curToken is SLASH.
+// ReadRegex reads a regex pattern and the flags after it. Called when curToken is SLASH.
 // At this point the lexer has already consumed the opening / and advanced.
 // So we just read until the closing /
-func (l *Lexer) ReadRegex() (string, error) {
-	var result string
+func (l *Lexer) ReadRegex() (string, string, error) {
+	result, err := l.readDelimited()
+	if err != nil {
+		return "", "", fmt.Errorf("unterminated regex")
+	}
+
+	flags, err := l.readRegexFlags("ims")
+	return result, flags, err
+}
+
+// ReadSubstitution reads the rest of s/pattern/replacement/flags. Called
+// when the lexer is at the / after the s.
+func (l *Lexer) ReadSubstitution() (string, string, string, error) {
+	if l.ch != '/' {
+		return "", "", "", fmt.Errorf("expected / after s")
+	}
+	l.readChar()
+	pattern, err := l.readDelimited()
+	if err != nil {
+		return "", "", "", fmt.Errorf("unterminated s///")
+	}
+	replacement, err := l.readDelimited()
+	if err != nil {
+		return "", "", "", fmt.Errorf("unterminated s///")
+	}
+	flags, err := l.readRegexFlags("gims")
+	return pattern, replacement, flags, err
+}
+
+// ReadTransliteration reads the rest of tr/from/to/. Called when the lexer
+// is at the / after the tr.
+func (l *Lexer) ReadTransliteration() (string, string, error) {
+	if l.ch != '/' {
+		return "", "", fmt.Errorf("expected / after tr")
+	}
+	l.readChar()
+	from, err := l.readDelimited()
+	if err != nil {
+		return "", "", fmt.Errorf("unterminated tr///")
+	}
+	to, err := l.readDelimited()
+	if err != nil {
+		return "", "", fmt.Errorf("unterminated tr///")
+	}
+	if isLetter(l.ch) {
+		return "", "", fmt.Errorf("unknown tr flag %c", l.ch)
+	}
+	return from, to, nil
+}
+
+// readDelimited reads up to the next unescaped / on the line and skips
+// past it. Escapes are kept as written.
+func (l *Lexer) readDelimited() (string, error) {
+	var result strings.Builder
 
 	for l.ch != '/' && l.ch != 0 && l.ch != '\n' {
 		if l.ch == '\\' {
-			result += string(l.ch)
+			result.WriteByte(l.ch)
 			l.readChar()
 			if l.ch != 0 {
-				result += string(l.ch)
+				result.WriteByte(l.ch)
 			}
 		} else {
-			result += string(l.ch)
+			result.WriteByte(l.ch)
 		}
 		l.readChar()
 	}
 
 	if l.ch != '/' {
-		return "", fmt.Errorf("unterminated regex")
+		return "", fmt.Errorf("missing closing /")
 	}
 	l.readChar() // skip closing /
 
-	return result, nil
+	return result.String(), nil
+}
+
+// readRegexFlags reads the flags right after a regex's closing /
+func (l *Lexer) readRegexFlags(allowed string) (string, error) {
+	var flags string
+	for isLetter(l.ch) {
+		if !strings.ContainsRune(allowed, rune(l.ch)) {
+			return "", fmt.Errorf("unknown regex flag %c", l.ch)
+		}
+		if !strings.ContainsRune(flags, rune(l.ch)) {
+			flags += string(l.ch)
+		}
+		l.readChar()
+	}
+	return flags, nil
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -0,0 +1,60 @@
+package lexer
+
+import (
+	"pearl/token"
+	"testing"
+	"unicode/utf8"
+)
+
+// TestUnicodeRoundTrip checks string literals come back byte for byte and
+// columns count characters rather than bytes
+func TestUnicodeRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"ascii", "plain"},
+		{"precomposed accent", "caf\u00e9"},
+		{"combining accent", "cafe\u0301"},
+		{"CJK", "日本語です"},
+		{"emoji with zero width joiners", "\U0001f468\u200d\U0001f469\u200d\U0001f467"},
+		{"skin tone", "\U0001f44d\U0001f3fd"},
+		{"flags", "\U0001f1ef\U0001f1f5\U0001f1eb\U0001f1f7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after := 5 + utf8.RuneCountInString(tt.text) + 3 // x = "text" y
+			want := []token.Token{
+				{Type: token.IDENT, Literal: "x", Col: 1},
+				{Type: token.ASSIGN, Literal: "=", Col: 3},
+				{Type: token.STRING, Literal: tt.text, Col: 5},
+				{Type: token.IDENT, Literal: "y", Col: after},
+			}
+
+			l := New(`x = "` + tt.text + `" y`)
+			for _, w := range want {
+				tok := l.NextToken()
+				if tok.Type != w.Type || tok.Literal != w.Literal || tok.Col != w.Col {
+					t.Fatalf("got %s %q at col %d, want %s %q at col %d",
+						tok.Type, tok.Literal, tok.Col, w.Type, w.Literal, w.Col)
+				}
+			}
+		})
+	}
+}
+
+// TestStrayCharacter checks a character that can't start a token is one
+// ILLEGAL token holding all of its bytes
+func TestStrayCharacter(t *testing.T) {
+	for _, ch := range []string{"é", "日", "\U0001f468", "\U0001f1ef"} {
+		l := New(ch + " 1")
+		tok := l.NextToken()
+		if tok.Type != token.ILLEGAL || tok.Literal != ch {
+			t.Errorf("got %s %q, want ILLEGAL %q", tok.Type, tok.Literal, ch)
+		}
+		if tok = l.NextToken(); tok.Type != token.INT || tok.Col != 3 {
+			t.Errorf("after %q got %s %q at col %d, want INT at col 3", ch, tok.Type, tok.Literal, tok.Col)
+		}
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"pearl/ast"
+	"pearl/compiler"
 	"pearl/evaluator"
 	"pearl/lexer"
 	"pearl/object"
 	"pearl/parser"
 	"pearl/repl"
+	"pearl/vm"
+	"strings"
 )
 
 func main() {
@@ -16,6 +20,10 @@ func main() {
 	fileFlag := flag.String("f", "", "file to run")
 	evalFlag := flag.String("e", "", "evaluate expression")
 	checkFlag := flag.Bool("check", false, "just check syntax, dont run")
+	vmFlag := flag.Bool("vm", false, "compile to bytecode and run on the vm")
+	nFlag := flag.Bool("n", false, "run the code once per line of stdin, with the line in \"line\"")
+	pFlag := flag.Bool("p", false, "like -n, but print \"line\" after each run")
+	sepFlag := flag.String("F", "", "split each line on this separator into \"fields\" (implies -n)")
 	versionFlag := flag.Bool("version", false, "print version")
 	helpFlag := flag.Bool("help", false, "show help")
 
@@ -26,6 +34,8 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  pearl -f <file>        Run a file\n")
 		fmt.Fprintf(os.Stderr, "  pearl -e '<code>'      Evaluate code\n")
 		fmt.Fprintf(os.Stderr, "  pearl <file>           Run a file (shorthand)\n")
+		fmt.Fprintf(os.Stderr, "  pearl -vm <file>       Run a file on the bytecode vm\n")
+		fmt.Fprintf(os.Stderr, "  pearl -n -e '<code>'   Run code for each line of stdin\n")
 		fmt.Fprintf(os.Stderr, "\nFlags:\n")
 		flag.PrintDefaults()
 	}
@@ -42,20 +52,31 @@ func main() {
 		return
 	}
 
+	opts := options{
+		checkOnly: *checkFlag,
+		useVM:     *vmFlag,
+		perLine:   *nFlag || *pFlag || *sepFlag != "",
+		printLine: *pFlag,
+		fieldSep:  *sepFlag,
+	}
+
 	// handle -e flag
 	if *evalFlag != "" {
-		runCode(*evalFlag, *checkFlag)
+		opts.args = flag.Args()
+		runCode(*evalFlag, "", opts)
 		return
 	}
 
-	// handle file argument
+	// handle file argument, anything after it is the script's args
 	filename := *fileFlag
+	opts.args = flag.Args()
 	if filename == "" && flag.NArg() > 0 {
 		filename = flag.Arg(0)
+		opts.args = flag.Args()[1:]
 	}
 
 	if filename != "" {
-		runFile(filename, *checkFlag)
+		runFile(filename, opts)
 		return
 	}
 
@@ -63,17 +84,27 @@ func main() {
 	repl.Start(os.Stdin, os.Stdout)
 }
 
-func runFile(filename string, checkOnly bool) {
+// options are the flags that change how a program runs
+type options struct {
+	checkOnly bool
+	useVM     bool
+	perLine   bool   // -n: run once per input line
+	printLine bool   // -p: print line after each run
+	fieldSep  string // -F: split line into fields
+	args      []string
+}
+
+func runFile(filename string, opts options) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error: cant read file %s: %v\n", filename, err)
 		os.Exit(1)
 	}
 
-	runCode(string(data), checkOnly)
+	runCode(string(data), filename, opts)
 }
 
-func runCode(code string, checkOnly bool) {
+func runCode(code string, filename string, opts options) {
 	l := lexer.New(code)
 	p := parser.New(l)
 	program := p.ParseProgram()
@@ -85,16 +116,127 @@ func runCode(code string, checkOnly bool) {
 		os.Exit(1)
 	}
 
-	if checkOnly {
+	if opts.checkOnly {
 		fmt.Println("syntax ok")
 		return
 	}
 
-	env := object.NewEnvironment()
-	result := evaluator.Eval(program, env)
+	var b backend
+	if opts.useVM {
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			fmt.Fprintf(os.Stderr, "compile error: %s\n", err)
+			os.Exit(1)
+		}
+		machine := vm.New(comp.Bytecode())
+		machine.SetFile(filename)
+		b = &vmBackend{machine: machine}
+	} else {
+		env := object.NewEnvironment()
+		env.SetFile(filename)
+		b = &evalBackend{program: program, env: env}
+	}
+
+	args := &object.Array{Elements: []object.Object{}}
+	for _, a := range opts.args {
+		args.Elements = append(args.Elements, &object.String{Value: a})
+	}
+	b.set("args", args)
+
+	if !opts.perLine {
+		checkResult(b.run())
+		return
+	}
+
+	// -n and -p: run the whole program for every line, like awk and perl -n
+	for {
+		line, ok, err := evaluator.Stdin.ReadLine()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: cant read stdin: %v\n", err)
+			os.Exit(1)
+		}
+		if !ok {
+			return
+		}
+
+		b.set("line", &object.String{Value: line})
+		if opts.fieldSep != "" {
+			b.set("fields", splitFields(line, opts.fieldSep))
+		}
+		checkResult(b.run())
 
-	if result != nil && result.Type() == object.ERROR_OBJ {
-		fmt.Fprintln(os.Stderr, result.Inspect())
+		if opts.printLine {
+			if val, ok := b.get("line"); ok {
+				fmt.Println(val.Inspect())
+			}
+		}
+	}
+}
+
+// splitFields splits on sep, or on runs of whitespace when sep is a space
+func splitFields(line, sep string) *object.Array {
+	var parts []string
+	if sep == " " {
+		parts = strings.Fields(line)
+	} else {
+		parts = strings.Split(line, sep)
+	}
+	fields := &object.Array{Elements: make([]object.Object, len(parts))}
+	for i, p := range parts {
+		fields.Elements[i] = &object.String{Value: p}
+	}
+	return fields
+}
+
+// checkResult exits for exit() and uncaught errors
+func checkResult(result object.Object) {
+	if errObj, ok := result.(*object.Error); ok {
+		if errObj.IsExit() {
+			os.Exit(errObj.Code)
+		}
+		fmt.Fprintln(os.Stderr, errObj.Inspect())
+		fmt.Fprint(os.Stderr, errObj.StackTrace())
 		os.Exit(1)
 	}
 }
+
+// backend runs a parsed program on the evaluator or the vm, and lets
+// -n/-p read and write its top level variables between runs
+type backend interface {
+	run() object.Object
+	set(name string, val object.Object)
+	get(name string) (object.Object, bool)
+}
+
+type evalBackend struct {
+	program *ast.Program
+	env     *object.Environment
+}
+
+func (b *evalBackend) run() object.Object {
+	return evaluator.Eval(b.program, b.env)
+}
+
+func (b *evalBackend) set(name string, val object.Object) {
+	b.env.Set(name, val)
+}
+
+func (b *evalBackend) get(name string) (object.Object, bool) {
+	return b.env.Get(name)
+}
+
+type vmBackend struct {
+	machine *vm.VM
+}
+
+func (b *vmBackend) run() object.Object {
+	return b.machine.Run()
+}
+
+func (b *vmBackend) set(name string, val object.Object) {
+	b.machine.SetGlobal(name, val)
+}
+
+func (b *vmBackend) get(name string) (object.Object, bool) {
+	return b.machine.Global(name)
+}
@@ -0,0 +1,99 @@
+package object
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// File is an open file handle. Reads are buffered so a file can be
+// streamed a line at a time without loading it all; writes go straight
+// to the file so nothing is lost if a script never calls close().
+type File struct {
+	Path   string
+	Mode   string // "r", "w" or "a"
+	handle *os.File
+	reader *bufio.Reader
+	closed bool
+}
+
+func NewFile(path, mode string, handle *os.File) *File {
+	f := &File{Path: path, Mode: mode, handle: handle}
+	if mode == "r" {
+		f.reader = bufio.NewReader(handle)
+	}
+	return f
+}
+
+func (f *File) Type() ObjectType { return FILE_OBJ }
+func (f *File) Inspect() string  { return fmt.Sprintf("<file %s>", f.Path) }
+
+// ReadLine returns the next line without its line ending, and false
+// once the file is exhausted
+func (f *File) ReadLine() (string, bool, error) {
+	if f.closed {
+		return "", false, errors.New("file is closed")
+	}
+	if f.reader == nil {
+		return "", false, errors.New("file is not open for reading")
+	}
+
+	line, err := f.reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", false, err
+	}
+	if err != nil && line == "" {
+		return "", false, nil
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, true, nil
+}
+
+func (f *File) Write(s string) error {
+	if f.closed {
+		return errors.New("file is closed")
+	}
+	if f.reader != nil {
+		return errors.New("file is not open for writing")
+	}
+	_, err := f.handle.WriteString(s)
+	return err
+}
+
+// Reader gives direct access to the buffered reader, for parsers that
+// stream the file themselves
+func (f *File) Reader() (io.Reader, error) {
+	if f.closed {
+		return nil, errors.New("file is closed")
+	}
+	if f.reader == nil {
+		return nil, errors.New("file is not open for reading")
+	}
+	return f.reader, nil
+}
+
+// ReadAll returns everything left in the file
+func (f *File) ReadAll() (string, error) {
+	if f.closed {
+		return "", errors.New("file is closed")
+	}
+	if f.reader == nil {
+		return "", errors.New("file is not open for reading")
+	}
+	data, err := io.ReadAll(f.reader)
+	return string(data), err
+}
+
+// Close closes the file. Closing twice is not an error, and the
+// standard streams are never really closed.
+func (f *File) Close() error {
+	if f.closed || f.handle == os.Stdin || f.handle == os.Stdout || f.handle == os.Stderr {
+		return nil
+	}
+	f.closed = true
+	return f.handle.Close()
+}
@@ -0,0 +1,59 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+// FuzzHashKey checks that map keys are equal exactly when the values are:
+// strings by their text, numbers by their value whether integer or float,
+// and never across types
+func FuzzHashKey(f *testing.F) {
+	// strings the old h*31+c hash gave the same key
+	f.Add("Aa", "BB", int64(1), int64(1), 1.0, 1.5)
+	f.Add("AaAa", "BBBB", int64(0), int64(-1), math.Copysign(0, -1), 0.0)
+	f.Add("AaBB", "BBAa", int64(-1), int64(-1), -1.0, -1.0000000000000002)
+	f.Add("", "\x00", int64(1<<53), int64(1<<53+1), float64(1<<53), float64(1<<53+2))
+	f.Add("1", "true", int64(math.MaxInt64), int64(math.MinInt64), float64(math.MaxInt64), float64(math.MinInt64))
+	f.Add("é", "é", int64(3), int64(4), 3.0000000000000004, math.Inf(1))
+
+	f.Fuzz(func(t *testing.T, a, b string, i, j int64, x, y float64) {
+		if math.IsNaN(x) || math.IsNaN(y) {
+			t.Skip("NaN equals nothing, not even itself")
+		}
+
+		keys := []struct {
+			key   HashKey
+			value any
+		}{
+			{(&String{Value: a}).HashKey(), a},
+			{(&String{Value: b}).HashKey(), b},
+			{(&Integer{Value: i}).HashKey(), new(big.Float).SetInt64(i)},
+			{(&Integer{Value: j}).HashKey(), new(big.Float).SetInt64(j)},
+			{(&Float{Value: x}).HashKey(), new(big.Float).SetFloat64(x)},
+			{(&Float{Value: y}).HashKey(), new(big.Float).SetFloat64(y)},
+			{(&Boolean{Value: true}).HashKey(), true},
+			{(&Boolean{Value: false}).HashKey(), false},
+		}
+		for _, k1 := range keys {
+			for _, k2 := range keys {
+				equal := sameValue(k1.value, k2.value)
+				if (k1.key == k2.key) != equal {
+					t.Errorf("%v and %v: keys %v and %v, but values equal is %v",
+						k1.value, k2.value, k1.key, k2.key, equal)
+				}
+			}
+		}
+	})
+}
+
+// sameValue compares exactly, so 2^53 + 1 isn't the float 2^53 it rounds
+// to, and 0 is -0
+func sameValue(a, b any) bool {
+	if x, ok := a.(*big.Float); ok {
+		y, ok := b.(*big.Float)
+		return ok && x.Cmp(y) == 0
+	}
+	return a == b
+}
@@ -0,0 +1,106 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Map keeps its pairs in insertion order. index finds a key's slot in
+// entries in O(1); deleting leaves a hole in entries that gets compacted
+// away once holes make up half of it.
+type Map struct {
+	index   map[HashKey]int
+	entries []MapPair // Key is nil for deleted entries
+}
+
+type MapPair struct {
+	Key   Object
+	Value Object
+}
+
+func NewMap() *Map {
+	return &Map{index: make(map[HashKey]int)}
+}
+
+// Len is the number of pairs
+func (m *Map) Len() int {
+	return len(m.index)
+}
+
+// Get looks up key, which must be Hashable
+func (m *Map) Get(key Object) (Object, bool) {
+	i, ok := m.index[key.(Hashable).HashKey()]
+	if !ok {
+		return nil, false
+	}
+	return m.entries[i].Value, true
+}
+
+// Set adds or replaces the value for key, which must be Hashable. A
+// replaced key keeps its original position.
+func (m *Map) Set(key, val Object) {
+	hk := key.(Hashable).HashKey()
+	if i, ok := m.index[hk]; ok {
+		m.entries[i].Value = val
+		return
+	}
+	m.index[hk] = len(m.entries)
+	m.entries = append(m.entries, MapPair{Key: key, Value: val})
+}
+
+// Delete removes key, reporting whether it was there
+func (m *Map) Delete(key Object) bool {
+	hk := key.(Hashable).HashKey()
+	i, ok := m.index[hk]
+	if !ok {
+		return false
+	}
+	delete(m.index, hk)
+	m.entries[i] = MapPair{}
+
+	if len(m.entries) > 8 && len(m.index) < len(m.entries)/2 {
+		m.compact()
+	}
+	return true
+}
+
+// compact drops deleted entries and renumbers the index
+func (m *Map) compact() {
+	entries := make([]MapPair, 0, len(m.index))
+	for _, e := range m.entries {
+		if e.Key != nil {
+			m.index[e.Key.(Hashable).HashKey()] = len(entries)
+			entries = append(entries, e)
+		}
+	}
+	m.entries = entries
+}
+
+// Ordered returns the pairs in insertion order. It's a copy, so the map
+// can be changed while looping over it.
+func (m *Map) Ordered() []MapPair {
+	pairs := make([]MapPair, 0, len(m.index))
+	for _, e := range m.entries {
+		if e.Key != nil {
+			pairs = append(pairs, e)
+		}
+	}
+	return pairs
+}
+
+func (m *Map) Type() ObjectType { return MAP_OBJ }
+func (m *Map) Inspect() string {
+	var out bytes.Buffer
+
+	pairs := []string{}
+	for _, pair := range m.Ordered() {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
+	}
+
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
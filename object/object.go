@@ -3,7 +3,9 @@ package object
 import (
 	"bytes"
 	"fmt"
+	"math"
 	"pearl/ast"
+	"pearl/code"
 	"regexp"
 	"strings"
 )
@@ -17,13 +19,23 @@ const (
 	BOOLEAN_OBJ      = "BOOLEAN"
 	NULL_OBJ         = "NULL"
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
 	ERROR_OBJ        = "ERROR"
 	FUNCTION_OBJ     = "FUNCTION"
 	BUILTIN_OBJ      = "BUILTIN"
 	ARRAY_OBJ        = "ARRAY"
 	MAP_OBJ          = "MAP"
+	COMPILED_FN_OBJ  = "COMPILED_FUNCTION"
 	REGEX_OBJ        = "REGEX"
 	RANGE_OBJ        = "RANGE"
+	MODULE_OBJ       = "MODULE"
+	FILE_OBJ         = "FILE"
+	ITERATOR_OBJ     = "ITERATOR"
+	STRUCT_TYPE_OBJ  = "STRUCT_TYPE"
+	STRUCT_OBJ       = "STRUCT"
+	SUBST_OBJ        = "SUBSTITUTION"
+	TRANSLIT_OBJ     = "TRANSLITERATION"
 )
 
 type Object interface {
@@ -35,9 +47,13 @@ type Hashable interface {
 	HashKey() HashKey
 }
 
+// HashKey is a map key built from the value itself rather than a hash
+// of it, so two different keys can never collide. Strings keep their
+// text in Str; numbers and booleans fit in Value.
 type HashKey struct {
 	Type  ObjectType
 	Value uint64
+	Str   string
 }
 
 // Integer
@@ -59,6 +75,15 @@ type Float struct {
 func (f *Float) Type() ObjectType { return FLOAT_OBJ }
 func (f *Float) Inspect() string  { return fmt.Sprintf("%g", f.Value) }
 
+// HashKey makes whole floats the same key as the equal integer, since
+// 1 == 1.0
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+		return HashKey{Type: INTEGER_OBJ, Value: uint64(int64(f.Value))}
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 // String
 type String struct {
 	Value string
@@ -67,11 +92,7 @@ type String struct {
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return s.Value }
 func (s *String) HashKey() HashKey {
-	h := uint64(0)
-	for i := 0; i < len(s.Value); i++ {
-		h = h*31 + uint64(s.Value[i])
-	}
-	return HashKey{Type: s.Type(), Value: h}
+	return HashKey{Type: s.Type(), Str: s.Value}
 }
 
 // Boolean
@@ -105,27 +126,84 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break signals a break out of the innermost loop, or the labelled one
+type Break struct {
+	Label     string
+	Line, Col int // of the statement, for the error if no loop catches it
+}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+// Continue signals a jump to the next iteration of a loop
+type Continue struct {
+	Label     string
+	Line, Col int
+}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Error kinds, exposed to scripts through try/catch
+const (
+	RUNTIME_ERROR = "RuntimeError"
+	TYPE_ERROR    = "TypeError"
+	NAME_ERROR    = "NameError"
+	IO_ERROR      = "IOError"
+
+	// EXIT marks the error exit() unwinds the program with. try/catch
+	// doesn't catch it.
+	EXIT = "Exit"
+)
+
 // Error
 type Error struct {
 	Message string
+	Kind    string // defaults to RUNTIME_ERROR when empty
+	File    string // set when raised inside an imported module
 	Line    int
 	Col     int
+	Stack   []Frame // innermost call first
+	Code    int     // exit status, for EXIT
+}
+
+func (e *Error) IsExit() bool { return e.Kind == EXIT }
+
+// Frame is one Pearl function call an error passed through
+type Frame struct {
+	Function string
+	Line     int // call site
+	Col      int
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string {
+	if e.File != "" && e.Line > 0 {
+		return fmt.Sprintf("error in %s at line %d, col %d: %s", e.File, e.Line, e.Col, e.Message)
+	}
 	if e.Line > 0 {
-		return fmt.Sprintf("error at line %d: %s", e.Line, e.Message)
+		return fmt.Sprintf("error at line %d, col %d: %s", e.Line, e.Col, e.Message)
 	}
 	return fmt.Sprintf("error: %s", e.Message)
 }
 
+// StackTrace lists the calls the error unwound through, one per line,
+// or returns "" when it was raised at the top level
+func (e *Error) StackTrace() string {
+	var out bytes.Buffer
+	for _, f := range e.Stack {
+		out.WriteString(fmt.Sprintf("  in %s, called at line %d, col %d\n", f.Function, f.Line, f.Col))
+	}
+	return out.String()
+}
+
 // Function
 type Function struct {
 	Parameters []*ast.FunctionParam
 	Body       *ast.BlockStatement
 	Env        *Environment
 	Name       string
+	Generator  bool // calling it returns an iterator over what it yields
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
@@ -134,7 +212,11 @@ func (f *Function) Inspect() string {
 
 	params := []string{}
 	for _, p := range f.Parameters {
-		params = append(params, p.Name.String())
+		if p.Rest {
+			params = append(params, "..."+p.Name.String())
+		} else {
+			params = append(params, p.Name.String())
+		}
 	}
 
 	out.WriteString("fn")
@@ -148,12 +230,76 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// CompiledFunction is a function body compiled to bytecode for the vm
+type CompiledFunction struct {
+	Instructions code.Instructions
+	NumLocals    int
+	Name         string
+	Params       []string
+	HasDefault   []bool
+	Variadic     bool        // the last parameter collects extra arguments
+	Generator    bool        // calling it returns an iterator over what it yields
+	LocalNames   []string    // slot names, for undefined variable errors
+	Positions    []SourcePos // sorted by Offset
+}
+
+// SourcePos maps an instruction offset back to the source
+type SourcePos struct {
+	Offset int
+	Line   int
+	Col    int
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FN_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// PositionAt returns the source position of the instruction at offset
+func (cf *CompiledFunction) PositionAt(offset int) (int, int) {
+	line, col := 0, 0
+	for _, p := range cf.Positions {
+		if p.Offset > offset {
+			break
+		}
+		line, col = p.Line, p.Col
+	}
+	return line, col
+}
+
+// Closure is a compiled function plus the variables it captured. It
+// reports itself as FUNCTION so scripts can't tell the backends apart.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+
+	// Call runs the closure on the vm that made it, for builtins like map
+	// that call back into Pearl code
+	Call func(cl *Closure, args []Object) Object
+}
+
+func (c *Closure) Type() ObjectType { return FUNCTION_OBJ }
+func (c *Closure) Inspect() string {
+	var out bytes.Buffer
+
+	out.WriteString("fn")
+	if c.Fn.Name != "" {
+		out.WriteString(" " + c.Fn.Name)
+	}
+	out.WriteString("(")
+	out.WriteString(strings.Join(c.Fn.Params, ", "))
+	out.WriteString(") {...}")
+
+	return out.String()
+}
+
 // BuiltinFunction
 type BuiltinFunction func(args ...Object) Object
 
 type Builtin struct {
-	Fn   BuiltinFunction
-	Name string
+	Fn     BuiltinFunction
+	Name   string
+	Params []string // parameter names, for builtins that take named arguments
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -180,54 +326,52 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
-// Map
-type Map struct {
-	Pairs map[HashKey]MapPair
-}
-
-type MapPair struct {
-	Key   Object
-	Value Object
-}
-
-func (m *Map) Type() ObjectType { return MAP_OBJ }
-func (m *Map) Inspect() string {
-	var out bytes.Buffer
-
-	pairs := []string{}
-	for _, pair := range m.Pairs {
-		pairs = append(pairs, fmt.Sprintf("%s: %s", pair.Key.Inspect(), pair.Value.Inspect()))
-	}
-
-	out.WriteString("{")
-	out.WriteString(strings.Join(pairs, ", "))
-	out.WriteString("}")
-
-	return out.String()
-}
-
 // Regex
 type Regex struct {
 	Pattern string
+	Flags   string // any of i, m and s, as in /abc/i
 	Regexp  *regexp.Regexp
 }
 
-func (r *Regex) Type() ObjectType { return REGEX_OBJ }
-func (r *Regex) Inspect() string  { return "/" + r.Pattern + "/" }
+// NewRegex compiles pattern with the flags written after a regex literal
+func NewRegex(pattern, flags string) (*Regex, error) {
+	src := pattern
+	if flags != "" {
+		src = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return &Regex{Pattern: pattern, Flags: flags, Regexp: re}, nil
+}
 
-// Range
-type Range struct {
-	Start int64
-	End   int64
+func (r *Regex) Type() ObjectType { return REGEX_OBJ }
+func (r *Regex) Inspect() string  { return "/" + r.Pattern + "/" + r.Flags }
+
+// Iterator produces values for a for loop one at a time, for sources
+// that are read lazily. Next returns false when it's done, or an *Error
+// as the value if producing one failed. Close, when set, releases what
+// the iterator holds if it's left before the end. Held is set once the
+// iterator is read from a variable, element or field, where something
+// else can still ask it for values, so it's only closed by the code that
+// created it.
+type Iterator struct {
+	Name  string
+	Next  func() (Object, bool)
+	Close func()
+	Held  bool
 }
 
-func (r *Range) Type() ObjectType { return RANGE_OBJ }
-func (r *Range) Inspect() string  { return fmt.Sprintf("%d..%d", r.Start, r.End) }
+func (it *Iterator) Type() ObjectType { return ITERATOR_OBJ }
+func (it *Iterator) Inspect() string  { return fmt.Sprintf("<iterator %s>", it.Name) }
 
 // Environment
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	file  string       // script or module the environment belongs to
+	yield func(Object) // set on a generator call's environment
 }
 
 func NewEnvironment() *Environment {
@@ -265,3 +409,52 @@ func (e *Environment) Update(name string, val Object) bool {
 	}
 	return false
 }
+
+// SetFile records the file whose top level this environment is
+func (e *Environment) SetFile(path string) {
+	e.file = path
+}
+
+// File returns the file the environment's code came from, or "" for
+// code that didn't come from a file (-e, the repl)
+func (e *Environment) File() string {
+	if e.file == "" && e.outer != nil {
+		return e.outer.File()
+	}
+	return e.file
+}
+
+// SetYield gives a generator call's environment the function that hands
+// yielded values to the caller
+func (e *Environment) SetYield(yield func(Object)) {
+	e.yield = yield
+}
+
+// Yield returns the yield function of the generator call the environment
+// is part of, or nil
+func (e *Environment) Yield() func(Object) {
+	if e.yield == nil && e.outer != nil {
+		return e.outer.Yield()
+	}
+	return e.yield
+}
+
+// Module is an imported .pearl file. Its exports are the top level
+// bindings whose names don't start with an underscore.
+type Module struct {
+	Name string
+	Path string
+	Env  *Environment
+}
+
+func (m *Module) Type() ObjectType { return MODULE_OBJ }
+func (m *Module) Inspect() string  { return fmt.Sprintf("<module %s>", m.Name) }
+
+// Export looks up an exported binding
+func (m *Module) Export(name string) (Object, bool) {
+	if strings.HasPrefix(name, "_") {
+		return nil, false
+	}
+	obj, ok := m.Env.store[name]
+	return obj, ok
+}
@@ -0,0 +1,203 @@
+package object
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Range is start..end, or start..=end when Inclusive, counting by Step,
+// which is negative when the range counts down. Ranges work out their
+// values as they're asked for rather than holding them. Float is set when
+// a bound or the step was a float, and then FStart, FEnd and FStep are used
+// in place of Start, End and Step.
+type Range struct {
+	Start, End, Step    int64
+	FStart, FEnd, FStep float64
+	Float               bool
+	Inclusive           bool
+	StepGiven           bool // written with step, rather than counting by one
+}
+
+// NewRange makes an integer range. A step of 0 means none was given, and
+// the range counts by one towards end. A positive step counts towards end
+// in strides of that size; a negative one only counts down, so the range
+// is empty when end is above start.
+func NewRange(start, end, step int64, inclusive bool) *Range {
+	r := &Range{Start: start, End: end, Step: step, Inclusive: inclusive, StepGiven: step != 0}
+	if step == 0 {
+		r.Step = 1
+	}
+	if r.Step > 0 && end < start {
+		r.Step = -r.Step
+	}
+	return r
+}
+
+// NewFloatRange is NewRange for floats
+func NewFloatRange(start, end, step float64, inclusive bool) *Range {
+	r := &Range{FStart: start, FEnd: end, FStep: step, Float: true, Inclusive: inclusive, StepGiven: step != 0}
+	if step == 0 {
+		r.FStep = 1
+	}
+	if r.FStep > 0 && end < start {
+		r.FStep = -r.FStep
+	}
+	return r
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	op := ".."
+	if r.Inclusive {
+		op = "..="
+	}
+	if r.Float {
+		s := fmt.Sprintf("%g%s%g", r.FStart, op, r.FEnd)
+		if r.StepGiven {
+			s += fmt.Sprintf(" step %g", r.FStep)
+		}
+		return s
+	}
+	s := fmt.Sprintf("%d%s%d", r.Start, op, r.End)
+	if r.StepGiven {
+		s += fmt.Sprintf(" step %d", r.Step)
+	}
+	return s
+}
+
+// Len is the number of values in the range. ok is false when there are
+// more than an int64 can count, as in
+// -9223372036854775807..9223372036854775807, and n is then math.MaxInt64.
+func (r *Range) Len() (n int64, ok bool) {
+	if r.Float {
+		steps := nearWhole((r.FEnd - r.FStart) / r.FStep)
+		switch {
+		case steps < 0:
+			return 0, true
+		case steps >= math.MaxInt64:
+			return math.MaxInt64, false
+		case r.Inclusive:
+			return int64(math.Floor(steps)) + 1, true
+		default:
+			return int64(math.Ceil(steps)), true
+		}
+	}
+
+	last, ok := r.lastStep()
+	switch {
+	case !ok:
+		return 0, true
+	case last >= math.MaxInt64:
+		return math.MaxInt64, false
+	}
+	return int64(last) + 1, true
+}
+
+// lastStep is how many steps from Start the last value of an integer
+// range is, and false when the range is empty. It works in uint64, where
+// the distance between any two int64s fits.
+func (r *Range) lastStep() (uint64, bool) {
+	if r.Step > 0 && r.End < r.Start || r.Step < 0 && r.End > r.Start {
+		return 0, false
+	}
+	span, stride := distance(r.Start, r.End), distance(0, r.Step)
+	if !r.Inclusive {
+		if span == 0 {
+			return 0, false
+		}
+		span--
+	}
+	return span / stride, true
+}
+
+// distance is how far apart a and b are
+func distance(a, b int64) uint64 {
+	if a > b {
+		a, b = b, a
+	}
+	return uint64(b) - uint64(a)
+}
+
+// At returns the i'th value, for 0 <= i < Len(). Float values are
+// rounded to 15 digits so that 0..1 step 0.1 gives 0.3 and not
+// 0.30000000000000004.
+func (r *Range) At(i int64) Object {
+	if r.Float {
+		v := r.FStart + float64(i)*r.FStep
+		v, _ = strconv.ParseFloat(strconv.FormatFloat(v, 'g', 15, 64), 64)
+		return &Float{Value: v}
+	}
+	return &Integer{Value: r.Start + i*r.Step}
+}
+
+// Contains reports whether v is one of the range's values. A range written
+// without a step also holds everything between its bounds, so 2.5 is in
+// 1..10, the way match arms have always treated ranges.
+func (r *Range) Contains(v Object) bool {
+	var f float64
+	switch v := v.(type) {
+	case *Integer:
+		if !r.Float {
+			return r.containsInt(v.Value)
+		}
+		f = float64(v.Value)
+	case *Float:
+		f = v.Value
+	default:
+		return false
+	}
+
+	if !r.StepGiven {
+		start, end := r.FStart, r.FEnd
+		if !r.Float {
+			start, end = float64(r.Start), float64(r.End)
+		}
+		if start > end {
+			start, end, f = -start, -end, -f
+		}
+		return f >= start && (f < end || r.Inclusive && f == end)
+	}
+	if !r.Float {
+		return f == math.Trunc(f) && r.containsInt(int64(f))
+	}
+	k := (f - r.FStart) / r.FStep
+	whole := math.Round(k)
+	n, _ := r.Len()
+	return math.Abs(k-whole) < 1e-9 && whole >= 0 && whole < float64(n)
+}
+
+func (r *Range) containsInt(n int64) bool {
+	last, ok := r.lastStep()
+	if !ok || r.Step > 0 && n < r.Start || r.Step < 0 && n > r.Start {
+		return false
+	}
+	offset, stride := distance(r.Start, n), distance(0, r.Step)
+	return offset%stride == 0 && offset/stride <= last
+}
+
+// Reverse returns the range counting the other way, over the same values
+func (r *Range) Reverse() *Range {
+	rev := *r
+	if n, _ := r.Len(); n == 0 {
+		return &rev
+	}
+	rev.Inclusive = true
+	if r.Float {
+		n, _ := r.Len()
+		rev.FStart, rev.FEnd, rev.FStep = r.At(n-1).(*Float).Value, r.FStart, -r.FStep
+	} else {
+		// wraps on the way but lands on the last value, which fits
+		last, _ := r.lastStep()
+		rev.Start, rev.End, rev.Step = r.Start+int64(last)*r.Step, r.Start, -r.Step
+	}
+	return &rev
+}
+
+// nearWhole rounds x to a whole number when it's only off by float error
+func nearWhole(x float64) float64 {
+	if whole := math.Round(x); math.Abs(x-whole) < 1e-9 {
+		return whole
+	}
+	return x
+}
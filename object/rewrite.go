@@ -0,0 +1,152 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Substitution is s/pattern/replacement/flags, applied to a string with ~
+type Substitution struct {
+	Regex       *Regex
+	Replacement string // a template like sub()'s, with $1 and ${name}
+	Global      bool
+	Source      string
+}
+
+// NewSubstitution compiles s/pattern/replacement/flags, where flags are g
+// to replace every match plus the regex flags
+func NewSubstitution(pattern, replacement, flags string) (*Substitution, error) {
+	re, err := NewRegex(pattern, strings.ReplaceAll(flags, "g", ""))
+	if err != nil {
+		return nil, err
+	}
+	return &Substitution{
+		Regex:       re,
+		Replacement: unescapeReplacement(replacement),
+		Global:      strings.Contains(flags, "g"),
+		Source:      "s/" + pattern + "/" + replacement + "/" + flags,
+	}, nil
+}
+
+func (s *Substitution) Type() ObjectType { return SUBST_OBJ }
+func (s *Substitution) Inspect() string  { return s.Source }
+
+// unescapeReplacement handles the backslash escapes in the replacement of
+// s///. \$ stays a literal dollar sign, so it becomes the template's $$.
+func unescapeReplacement(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case '$':
+			out.WriteString("$$")
+		case '/', '\\':
+			out.WriteByte(s[i])
+		default:
+			out.WriteByte('\\')
+			out.WriteByte(s[i])
+		}
+	}
+	return out.String()
+}
+
+// Transliteration is tr/from/to/, which swaps each character of from for
+// the one at the same position in to
+type Transliteration struct {
+	table  map[rune]rune
+	Source string
+}
+
+// NewTransliteration builds tr/from/to/. Both sides can use ranges like
+// a-z. A shorter to repeats its last character, and an empty one leaves
+// the characters as they are.
+func NewTransliteration(from, to string) (*Transliteration, error) {
+	fromChars, err := expandCharList(from)
+	if err != nil {
+		return nil, err
+	}
+	toChars, err := expandCharList(to)
+	if err != nil {
+		return nil, err
+	}
+	if len(toChars) == 0 {
+		toChars = fromChars
+	}
+
+	table := make(map[rune]rune)
+	for i, r := range fromChars {
+		if _, ok := table[r]; ok {
+			continue
+		}
+		if i < len(toChars) {
+			table[r] = toChars[i]
+		} else {
+			table[r] = toChars[len(toChars)-1]
+		}
+	}
+	return &Transliteration{table: table, Source: "tr/" + from + "/" + to + "/"}, nil
+}
+
+func (t *Transliteration) Type() ObjectType { return TRANSLIT_OBJ }
+func (t *Transliteration) Inspect() string  { return t.Source }
+
+// Apply returns s with its characters swapped
+func (t *Transliteration) Apply(s string) string {
+	return strings.Map(func(r rune) rune {
+		if to, ok := t.table[r]; ok {
+			return to
+		}
+		return r
+	}, s)
+}
+
+// expandCharList turns "a-cx\-" into a, b, c, x, -
+func expandCharList(s string) ([]rune, error) {
+	var chars []rune
+	var escaped []bool
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '\\' && i+1 < len(runes) {
+			i++
+			switch runes[i] {
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			default:
+				r = runes[i]
+			}
+			chars = append(chars, r)
+			escaped = append(escaped, true)
+			continue
+		}
+		chars = append(chars, r)
+		escaped = append(escaped, false)
+	}
+
+	var out []rune
+	for i := 0; i < len(chars); i++ {
+		if i+2 < len(chars) && chars[i+1] == '-' && !escaped[i+1] {
+			lo, hi := chars[i], chars[i+2]
+			if lo > hi {
+				return nil, fmt.Errorf("invalid range %c-%c in tr", lo, hi)
+			}
+			for r := lo; r <= hi; r++ {
+				out = append(out, r)
+			}
+			i += 2
+			continue
+		}
+		out = append(out, chars[i])
+	}
+	return out, nil
+}
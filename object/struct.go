@@ -0,0 +1,80 @@
+package object
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// StructType is what a struct declaration defines. Calling it builds a
+// Struct; Init binds the constructor arguments like any function call
+// and returns the field values as an array.
+type StructType struct {
+	Name    string
+	Fields  []string
+	Init    Object
+	Methods map[string]Object
+}
+
+func (st *StructType) Type() ObjectType { return STRUCT_TYPE_OBJ }
+func (st *StructType) Inspect() string  { return fmt.Sprintf("<struct %s>", st.Name) }
+
+// Method looks up a method declared with fn Type.name(self, ...)
+func (st *StructType) Method(name string) (Object, bool) {
+	m, ok := st.Methods[name]
+	return m, ok
+}
+
+// Struct is an instance of a StructType, with one value per field
+type Struct struct {
+	Def    *StructType
+	Values []Object // in Def.Fields order
+}
+
+func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
+func (s *Struct) Inspect() string {
+	var out bytes.Buffer
+
+	fields := []string{}
+	for i, name := range s.Def.Fields {
+		fields = append(fields, fmt.Sprintf("%s: %s", name, s.Values[i].Inspect()))
+	}
+
+	out.WriteString(s.Def.Name)
+	out.WriteString("{")
+	out.WriteString(strings.Join(fields, ", "))
+	out.WriteString("}")
+
+	return out.String()
+}
+
+// Pairs lists the fields with their values, keyed by field name
+func (s *Struct) Pairs() []MapPair {
+	pairs := make([]MapPair, len(s.Values))
+	for i, name := range s.Def.Fields {
+		pairs[i] = MapPair{Key: &String{Value: name}, Value: s.Values[i]}
+	}
+	return pairs
+}
+
+// Get returns the value of field name
+func (s *Struct) Get(name string) (Object, bool) {
+	for i, f := range s.Def.Fields {
+		if f == name {
+			return s.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+// Set changes field name, reporting false if there's no such field.
+// Structs have a fixed set of fields, so new ones can't be added.
+func (s *Struct) Set(name string, val Object) bool {
+	for i, f := range s.Def.Fields {
+		if f == name {
+			s.Values[i] = val
+			return true
+		}
+	}
+	return false
+}
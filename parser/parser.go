@@ -5,7 +5,9 @@ import (
 	"pearl/ast"
 	"pearl/lexer"
 	"pearl/token"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
 // precedence levels
@@ -40,7 +42,9 @@ var precedences = map[token.TokenType]int{
 	token.GTE:      LESSGREATER,
 	token.MATCH:    MATCH_PREC,
 	token.NOTMATCH: MATCH_PREC,
+	token.MATCH_ASSIGN: ASSIGN_PREC,
 	token.RANGE:    RANGE_PREC,
+	token.RANGE_INCL: RANGE_PREC,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.CONCAT:   SUM,
@@ -49,6 +53,7 @@ var precedences = map[token.TokenType]int{
 	token.PERCENT:  PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
 }
 
 type (
@@ -62,6 +67,11 @@ type Parser struct {
 
 	curToken  token.Token
 	peekToken token.Token
+	peekMark  lexer.Mark // lexer position just before peekToken
+
+	// generator is the Generator flag of the function being parsed, so a
+	// yield can set it; nil outside functions
+	generator *bool
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -87,6 +97,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseMapLiteral)
 	p.registerPrefix(token.SLASH, p.parseRegexLiteral)
+	p.registerPrefix(token.MATCH_KW, p.parseMatchBlock)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
@@ -105,10 +116,13 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.MATCH, p.parseMatchExpression)
 	p.registerInfix(token.NOTMATCH, p.parseMatchExpression)
+	p.registerInfix(token.MATCH_ASSIGN, p.parseMatchAssign)
 	p.registerInfix(token.RANGE, p.parseRangeExpression)
+	p.registerInfix(token.RANGE_INCL, p.parseRangeExpression)
 	p.registerInfix(token.PIPE, p.parsePipeExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
 
 	// read two tokens so curToken and peekToken are both set
@@ -138,6 +152,7 @@ func (p *Parser) addError(format string, args ...interface{}) {
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
+	p.peekMark = p.l.Mark()
 	p.peekToken = p.l.NextToken()
 }
 
@@ -203,10 +218,27 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.YIELD:
+		return p.parseYieldStatement()
 	case token.FOR:
 		return p.parseForStatement()
 	case token.WHILE:
 		return p.parseWhileStatement()
+	case token.TRY:
+		return p.parseTryStatement()
+	case token.BREAK:
+		return p.parseBreakStatement()
+	case token.CONTINUE:
+		return p.parseContinueStatement()
+	case token.IMPORT:
+		return p.parseImportStatement()
+	case token.STRUCT:
+		return p.parseStructStatement()
+	case token.IDENT:
+		if p.peekTokenIs(token.COLON) {
+			return p.parseLabelledLoop()
+		}
+		return p.parseExpressionStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -215,12 +247,19 @@ func (p *Parser) parseStatement() ast.Statement {
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
-		return nil
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -252,13 +291,51 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseYieldStatement() ast.Statement {
+	stmt := &ast.YieldStatement{Token: p.curToken}
+	if p.generator == nil {
+		p.addError("yield outside a function")
+		return nil
+	}
+	*p.generator = true
+
+	p.nextToken()
+
+	if !p.curTokenIs(token.NEWLINE) && !p.curTokenIs(token.SEMICOLON) && !p.curTokenIs(token.EOF) && !p.curTokenIs(token.RBRACE) {
+		stmt.Value = p.parseExpression(LOWEST)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseForStatement() *ast.ForStatement {
 	stmt := &ast.ForStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
+	p.nextToken()
+	stmt.Variable = p.parsePattern()
+	if stmt.Variable == nil {
 		return nil
 	}
-	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	// for i, x in arr
+	if p.peekTokenIs(token.COMMA) {
+		index, ok := stmt.Variable.(*ast.Identifier)
+		if !ok {
+			p.addError("expected a name before the comma in for, got %s", stmt.Variable.String())
+			return nil
+		}
+		stmt.Index = index
+		p.nextToken()
+		p.nextToken()
+		stmt.Variable = p.parsePattern()
+		if stmt.Variable == nil {
+			return nil
+		}
+	}
 
 	if !p.expectPeek(token.IN) {
 		return nil
@@ -275,6 +352,98 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 	return stmt
 }
 
+// parsePattern parses a binding target at the current token: a name,
+// [a, b, ...rest] or {name, age: years, ...rest}
+func (p *Parser) parsePattern() ast.Expression {
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseMapPattern()
+	default:
+		p.addError("expected a name or a [ or { pattern, got %s", p.curToken.Literal)
+		return nil
+	}
+}
+
+func (p *Parser) parseArrayPattern() ast.Expression {
+	pat := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pat.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		el := p.parsePattern()
+		if el == nil {
+			return nil
+		}
+		pat.Elements = append(pat.Elements, el)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return pat
+}
+
+func (p *Parser) parseMapPattern() ast.Expression {
+	pat := &ast.MapPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pat.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		if !p.curTokenIs(token.IDENT) && !p.curTokenIs(token.STRING) {
+			p.addError("expected a key in the pattern, got %s", p.curToken.Literal)
+			return nil
+		}
+		key := p.curToken
+		var value ast.Expression = &ast.Identifier{Token: key, Value: key.Literal}
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			value = p.parsePattern()
+			if value == nil {
+				return nil
+			}
+		} else if key.Type == token.STRING {
+			p.addError("expected : after the key %q in the pattern", key.Literal)
+			return nil
+		}
+		pat.Keys = append(pat.Keys, unescapeString(key.Literal))
+		pat.Values = append(pat.Values, value)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+	return pat
+}
+
 func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 	stmt := &ast.WhileStatement{Token: p.curToken}
 
@@ -289,6 +458,220 @@ func (p *Parser) parseWhileStatement() *ast.WhileStatement {
 	return stmt
 }
 
+// parseLabelledLoop handles `name: for ...` and `name: while ...`
+func (p *Parser) parseLabelledLoop() ast.Statement {
+	label := p.curToken.Literal
+	p.nextToken() // skip the colon
+
+	switch p.peekToken.Type {
+	case token.FOR:
+		p.nextToken()
+		stmt := p.parseForStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Label = label
+		return stmt
+	case token.WHILE:
+		p.nextToken()
+		stmt := p.parseWhileStatement()
+		if stmt == nil {
+			return nil
+		}
+		stmt.Label = label
+		return stmt
+	default:
+		p.addError("label %s must be followed by a loop", label)
+		return nil
+	}
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		stmt.Label = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		stmt.Label = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseTryStatement() *ast.TryStatement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+
+	// allow catch on the line after the closing brace
+	for p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.CATCH) {
+		return nil
+	}
+
+	// optional name for the caught error
+	if p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		stmt.CatchVar = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	stmt.Catch = p.parseBlockStatement()
+
+	return stmt
+}
+
+// parseImportStatement handles `import "path" as name` and
+// `import { a, b as c } from "path"`. as and from aren't reserved words,
+// so they're matched as identifiers here.
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	stmt := &ast.ImportStatement{Token: p.curToken}
+
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Names = []*ast.ImportName{}
+		for !p.peekTokenIs(token.RBRACE) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			name := &ast.ImportName{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+			if p.peekIsWord("as") {
+				p.nextToken()
+				if !p.expectPeek(token.IDENT) {
+					return nil
+				}
+				name.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			}
+			stmt.Names = append(stmt.Names, name)
+			if !p.peekTokenIs(token.COMMA) {
+				break
+			}
+			p.nextToken()
+		}
+		if !p.expectPeek(token.RBRACE) {
+			return nil
+		}
+		if !p.peekIsWord("from") {
+			p.addError("expected from after the import list, got %s", p.peekToken.Literal)
+			return nil
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = unescapeString(p.curToken.Literal)
+
+	if stmt.Names == nil && p.peekIsWord("as") {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Alias = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseStructStatement parses `struct Point { x, y = 0 }`, with fields
+// separated by commas or newlines
+func (p *Parser) parseStructStatement() *ast.StructStatement {
+	stmt := &ast.StructStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	for {
+		for p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.NEWLINE) {
+			p.nextToken()
+		}
+		if p.peekTokenIs(token.RBRACE) {
+			break
+		}
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		field := &ast.FunctionParam{
+			Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+		}
+		if seen[field.Name.Value] {
+			p.addError("duplicate field %s in struct %s", field.Name.Value, stmt.Name.Value)
+			return nil
+		}
+		seen[field.Name.Value] = true
+
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			field.Default = p.parseExpression(LOWEST)
+		}
+		stmt.Fields = append(stmt.Fields, field)
+	}
+	p.nextToken() // }
+
+	values := &ast.ArrayLiteral{Token: stmt.Token}
+	for _, f := range stmt.Fields {
+		values.Elements = append(values.Elements, f.Name)
+	}
+	stmt.Init = &ast.FunctionLiteral{
+		Token:      stmt.Token,
+		Parameters: stmt.Fields,
+		Body: &ast.BlockStatement{
+			Token:      stmt.Token,
+			Statements: []ast.Statement{&ast.ExpressionStatement{Token: stmt.Token, Expression: values}},
+		},
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.NEWLINE) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// peekIsWord reports whether the next token is the bare word w
+func (p *Parser) peekIsWord(w string) bool {
+	return p.peekTokenIs(token.IDENT) && p.peekToken.Literal == w
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 	stmt.Expression = p.parseExpression(LOWEST)
@@ -353,19 +736,31 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 }
 
 func (p *Parser) parseStringLiteral() ast.Expression {
-	lit := &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	lit := &ast.StringLiteral{Token: p.curToken, Value: unescapeString(p.curToken.Literal)}
 
 	// parse interpolation parts
 	lit.Parts = p.parseStringParts(p.curToken.Literal)
 	return lit
 }
 
+// unescapeString turns the lexer's marks for \{ back into plain braces
+func unescapeString(lit string) string {
+	return strings.ReplaceAll(lit, lexer.EscapedBrace, "{")
+}
+
 func (p *Parser) parseStringParts(s string) []ast.StringPart {
 	var parts []ast.StringPart
 	i := 0
 
 	for i < len(s) {
-		if s[i] == '{' {
+		if strings.HasPrefix(s[i:], lexer.EscapedBrace) {
+			parts = append(parts, ast.StringPart{IsExpr: false, Text: "{"})
+			i += len(lexer.EscapedBrace)
+		} else if strings.HasPrefix(s[i:], "{{") || strings.HasPrefix(s[i:], "}}") {
+			// doubled braces are literal ones, as in format strings
+			parts = append(parts, ast.StringPart{IsExpr: false, Text: s[i : i+1]})
+			i += 2
+		} else if s[i] == '{' {
 			// find matching }
 			depth := 1
 			start := i + 1
@@ -380,26 +775,34 @@ func (p *Parser) parseStringParts(s string) []ast.StringPart {
 			}
 
 			if depth == 0 {
-				exprStr := s[start : j-1]
-				// parse the expression
-				l := lexer.New(exprStr)
+				exprStr, spec := splitFormatSpec(s[start : j-1])
+
+				// parse the expression, with positions in the source for errors
+				line, col := p.l.StringPos(p.curToken.Line, p.curToken.Col, i)
+				l := lexer.NewAt(exprStr, line, col+1)
 				parser := New(l)
 				program := parser.ParseProgram()
 
-				if len(program.Statements) > 0 {
+				var expr ast.Expression
+				if len(parser.Errors()) == 0 && len(program.Statements) == 1 {
 					if es, ok := program.Statements[0].(*ast.ExpressionStatement); ok {
-						parts = append(parts, ast.StringPart{IsExpr: true, Expr: es.Expression})
+						expr = es.Expression
 					}
 				}
+				if expr == nil {
+					p.addError("%s in a string is not an expression, write {{ or \\{ for a literal brace", s[i:j])
+				} else {
+					parts = append(parts, ast.StringPart{IsExpr: true, Expr: expr, Spec: spec})
+				}
 				i = j
 			} else {
 				parts = append(parts, ast.StringPart{IsExpr: false, Text: string(s[i])})
 				i++
 			}
 		} else {
-			// regular text - collect until { or end
+			// regular text - collect until { or }} or end
 			start := i
-			for i < len(s) && s[i] != '{' {
+			for i < len(s) && s[i] != '{' && !strings.HasPrefix(s[i:], "}}") && !strings.HasPrefix(s[i:], lexer.EscapedBrace) {
 				i++
 			}
 			parts = append(parts, ast.StringPart{IsExpr: false, Text: s[start:i]})
@@ -409,6 +812,30 @@ func (p *Parser) parseStringParts(s string) []ast.StringPart {
 	return parts
 }
 
+// formatSpec matches the format specs evaluator/format.go understands,
+// [[fill]align][sign][#][0][width][,][.precision][type]
+var formatSpec = regexp.MustCompile(`^(.?[<>^])?[-+ ]?#?0?[0-9]*,?(\.[0-9]+)?[bcdeEfFgGosxX%]?$`)
+
+// splitFormatSpec splits "price:.2f" into the expression and its format
+// spec. Only a colon outside brackets counts, and only when what follows
+// is a valid spec.
+func splitFormatSpec(s string) (string, string) {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+		case ':':
+			if depth == 0 && i+1 < len(s) && formatSpec.MatchString(s[i+1:]) {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -420,13 +847,18 @@ func (p *Parser) parseNull() ast.Expression {
 func (p *Parser) parseRegexLiteral() ast.Expression {
 	lit := &ast.RegexLiteral{Token: p.curToken}
 
-	pattern, err := p.l.ReadRegex()
+	// peekToken was lexed from inside the pattern, so rewind and read it raw
+	p.l.Reset(p.peekMark)
+	pattern, flags, err := p.l.ReadRegex()
 	if err != nil {
 		p.addError("invalid regex: %s", err)
 		return nil
 	}
 
 	lit.Pattern = pattern
+	lit.Flags = flags
+	p.peekMark = p.l.Mark()
+	p.peekToken = p.l.NextToken()
 	return lit
 }
 
@@ -462,6 +894,7 @@ func (p *Parser) parseMapLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		m.Pairs[key] = value
+		m.Keys = append(m.Keys, key)
 
 		// skip trailing commas and newlines
 		for p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.NEWLINE) {
@@ -533,37 +966,101 @@ func (p *Parser) parseMatchExpression(left ast.Expression) ast.Expression {
 		Left:     left,
 	}
 
+	if p.peekTokenIs(token.IDENT) && (p.peekToken.Literal == "s" || p.peekToken.Literal == "tr") {
+		if expression.Operator != "~" {
+			p.addError("%s/// needs ~, not %s", p.peekToken.Literal, expression.Operator)
+			return nil
+		}
+		expression.Right = p.parseRewriteLiteral()
+		if expression.Right == nil {
+			return nil
+		}
+		return expression
+	}
+
 	// At this point curToken is ~ or !~, and peekToken is /
 	// The lexer has already read past the / to fill peekToken
 	// So we need to read the regex content directly (lexer.ch is at first char of pattern)
-	pattern, err := p.l.ReadRegex()
+	pattern, flags, err := p.l.ReadRegex()
 	if err != nil {
 		p.addError("invalid regex: %s", err)
 		return nil
 	}
 
-	re := &ast.RegexLiteral{Token: p.peekToken, Pattern: pattern}
+	re := &ast.RegexLiteral{Token: p.peekToken, Pattern: pattern, Flags: flags}
 	expression.Right = re
 
 	// The lexer has now moved past the closing /
 	// We need to resync the parser's token state
 	// curToken is ~, peekToken is / (stale)
 	// We should make curToken the regex and peek the next real token
-	p.curToken = token.Token{Type: token.REGEX, Literal: "/" + pattern + "/"}
+	p.curToken = token.Token{Type: token.REGEX, Literal: re.String()}
+	p.peekMark = p.l.Mark()
 	p.peekToken = p.l.NextToken()
 
 	return expression
 }
 
+// parseRewriteLiteral reads s/pattern/replacement/flags or tr/from/to/
+// after a ~. peekToken is the s or tr, and the lexer is at the / after it.
+func (p *Parser) parseRewriteLiteral() ast.Expression {
+	tok := p.peekToken
+	var lit ast.Expression
+	if tok.Literal == "s" {
+		pattern, replacement, flags, err := p.l.ReadSubstitution()
+		if err != nil {
+			p.addError("invalid substitution: %s", err)
+			return nil
+		}
+		lit = &ast.SubstituteLiteral{Token: tok, Pattern: pattern, Replacement: replacement, Flags: flags}
+	} else {
+		from, to, err := p.l.ReadTransliteration()
+		if err != nil {
+			p.addError("invalid transliteration: %s", err)
+			return nil
+		}
+		lit = &ast.TransliterateLiteral{Token: tok, From: from, To: to}
+	}
+
+	p.curToken = token.Token{Type: token.REGEX, Literal: lit.String(), Line: tok.Line, Col: tok.Col}
+	p.peekMark = p.l.Mark()
+	p.peekToken = p.l.NextToken()
+	return lit
+}
+
+// parseMatchAssign handles line ~= s/a/b/, which is line = line ~ s/a/b/
+func (p *Parser) parseMatchAssign(left ast.Expression) ast.Expression {
+	tok := p.curToken
+	if !p.peekTokenIs(token.IDENT) || (p.peekToken.Literal != "s" && p.peekToken.Literal != "tr") {
+		p.addError("~= needs s/// or tr/// on the right")
+		return nil
+	}
+
+	p.curToken = token.Token{Type: token.MATCH, Literal: "~", Line: tok.Line, Col: tok.Col}
+	value := p.parseMatchExpression(left)
+	if value == nil {
+		return nil
+	}
+	return &ast.AssignExpression{Token: tok, Name: left, Value: value}
+}
+
 func (p *Parser) parseRangeExpression(left ast.Expression) ast.Expression {
 	expression := &ast.RangeLiteral{
-		Token: p.curToken,
-		Start: left,
+		Token:     p.curToken,
+		Start:     left,
+		Inclusive: p.curTokenIs(token.RANGE_INCL),
 	}
 
 	p.nextToken()
 	expression.End = p.parseExpression(RANGE_PREC)
 
+	// step is only a keyword here, so it still works as a variable name
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "step" {
+		p.nextToken()
+		p.nextToken()
+		expression.Step = p.parseExpression(RANGE_PREC)
+	}
+
 	return expression
 }
 
@@ -628,6 +1125,55 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseMatchBlock() ast.Expression {
+	// match(...) is still the regex builtin
+	if p.peekTokenIs(token.LPAREN) {
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for {
+		// arms are separated by commas and/or newlines
+		for p.peekTokenIs(token.COMMA) || p.peekTokenIs(token.NEWLINE) {
+			p.nextToken()
+		}
+		if p.peekTokenIs(token.RBRACE) || p.peekTokenIs(token.EOF) {
+			break
+		}
+
+		p.nextToken()
+		arm := &ast.MatchArm{Pattern: p.parseExpression(LOWEST)}
+
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+
+		if p.peekTokenIs(token.LBRACE) {
+			p.nextToken()
+			arm.Body = p.parseBlockStatement()
+		} else {
+			p.nextToken()
+			arm.Body = p.parseExpression(LOWEST)
+		}
+
+		expression.Arms = append(expression.Arms, arm)
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return expression
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -653,10 +1199,19 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
-	// optional function name
+	// optional function name, or Type.name for a method
 	if p.peekTokenIs(token.IDENT) {
 		p.nextToken()
 		lit.Name = p.curToken.Literal
+
+		if p.peekTokenIs(token.DOT) {
+			lit.Receiver = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			lit.Name = p.curToken.Literal
+		}
 	}
 
 	if !p.expectPeek(token.LPAREN) {
@@ -669,7 +1224,10 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	outer := p.generator
+	p.generator = &lit.Generator
 	lit.Body = p.parseBlockStatement()
+	p.generator = outer
 
 	return lit
 }
@@ -684,33 +1242,20 @@ func (p *Parser) parseFunctionParameters() []*ast.FunctionParam {
 
 	p.nextToken()
 
-	param := &ast.FunctionParam{
-		Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
-	}
-
-	// check for default value
-	if p.peekTokenIs(token.ASSIGN) {
-		p.nextToken()
-		p.nextToken()
-		param.Default = p.parseExpression(LOWEST)
+	param := p.parseFunctionParam()
+	if param == nil {
+		return nil
 	}
-
 	params = append(params, param)
 
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
 
-		param := &ast.FunctionParam{
-			Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
-		}
-
-		if p.peekTokenIs(token.ASSIGN) {
-			p.nextToken()
-			p.nextToken()
-			param.Default = p.parseExpression(LOWEST)
+		param := p.parseFunctionParam()
+		if param == nil {
+			return nil
 		}
-
 		params = append(params, param)
 	}
 
@@ -718,9 +1263,56 @@ func (p *Parser) parseFunctionParameters() []*ast.FunctionParam {
 		return nil
 	}
 
+	for i, param := range params {
+		if param.Rest && i != len(params)-1 {
+			p.addError("rest parameter ...%s must be the last parameter", param.Name.Value)
+			return nil
+		}
+	}
+
 	return params
 }
 
+// parseFunctionParam parses a name or a destructuring pattern, with an
+// optional default value
+func (p *Parser) parseFunctionParam() *ast.FunctionParam {
+	param := &ast.FunctionParam{}
+
+	if p.curTokenIs(token.ELLIPSIS) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		param.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		param.Rest = true
+		if p.peekTokenIs(token.ASSIGN) {
+			p.addError("rest parameter ...%s can't have a default", param.Name.Value)
+			return nil
+		}
+		return param
+	}
+
+	if p.curTokenIs(token.LBRACKET) || p.curTokenIs(token.LBRACE) {
+		tok := p.curToken
+		param.Pattern = p.parsePattern()
+		if param.Pattern == nil {
+			return nil
+		}
+		// the pattern's text can't clash with a real name or be passed by name
+		param.Name = &ast.Identifier{Token: tok, Value: param.Pattern.String()}
+	} else {
+		param.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	// check for default value
+	if p.peekTokenIs(token.ASSIGN) {
+		p.nextToken()
+		p.nextToken()
+		param.Default = p.parseExpression(LOWEST)
+	}
+
+	return param
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseCallArguments()
@@ -736,36 +1328,52 @@ func (p *Parser) parseCallArguments() []ast.CallArg {
 	}
 
 	p.nextToken()
+	args = append(args, p.parseCallArg())
 
-	// check for named arg
+	for p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		p.nextToken()
+		args = append(args, p.parseCallArg())
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return args
+}
+
+// parseCallArg parses x, name = x, ...arr or **opts
+func (p *Parser) parseCallArg() ast.CallArg {
 	arg := ast.CallArg{}
-	if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.ASSIGN) {
+
+	switch {
+	case p.curTokenIs(token.IDENT) && p.peekTokenIs(token.ASSIGN):
 		arg.Name = p.curToken.Literal
 		p.nextToken() // skip =
 		p.nextToken()
-	}
-	arg.Value = p.parseExpression(LOWEST)
-	args = append(args, arg)
-
-	for p.peekTokenIs(token.COMMA) {
+	case p.curTokenIs(token.ELLIPSIS):
+		arg.Spread = "..."
+		p.nextToken()
+	case p.curTokenIs(token.ASTERISK) && p.peekTokenIs(token.ASTERISK):
+		arg.Spread = "**"
 		p.nextToken()
 		p.nextToken()
-
-		arg := ast.CallArg{}
-		if p.curTokenIs(token.IDENT) && p.peekTokenIs(token.ASSIGN) {
-			arg.Name = p.curToken.Literal
-			p.nextToken()
-			p.nextToken()
-		}
-		arg.Value = p.parseExpression(LOWEST)
-		args = append(args, arg)
 	}
 
-	if !p.expectPeek(token.RPAREN) {
+	arg.Value = p.parseExpression(LOWEST)
+	return arg
+}
+
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	exp := &ast.MemberExpression{Token: p.curToken, Object: left}
+
+	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
+	exp.Member = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
-	return args
+	return exp
 }
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"os"
 	"pearl/evaluator"
 	"pearl/lexer"
 	"pearl/object"
@@ -87,6 +88,9 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		evaluated := evaluator.Eval(program, env)
+		if errObj, ok := evaluated.(*object.Error); ok && errObj.IsExit() {
+			os.Exit(errObj.Code)
+		}
 		if evaluated != nil {
 			// dont print null for statements that dont return anything interesting
 			if evaluated.Type() != object.NULL_OBJ {
@@ -25,24 +25,28 @@ const (
 	REGEX  = "REGEX"
 
 	// operators
-	ASSIGN   = "="
-	PLUS     = "+"
-	MINUS    = "-"
-	BANG     = "!"
-	ASTERISK = "*"
-	SLASH    = "/"
-	PERCENT  = "%"
-	LT       = "<"
-	GT       = ">"
-	EQ       = "=="
-	NOT_EQ   = "!="
-	LTE      = "<="
-	GTE      = ">="
-	CONCAT   = "++"
-	PIPE     = "|>"
-	MATCH    = "~"
-	NOTMATCH = "!~"
-	RANGE    = ".."
+	ASSIGN       = "="
+	PLUS         = "+"
+	MINUS        = "-"
+	BANG         = "!"
+	ASTERISK     = "*"
+	SLASH        = "/"
+	PERCENT      = "%"
+	LT           = "<"
+	GT           = ">"
+	EQ           = "=="
+	NOT_EQ       = "!="
+	LTE          = "<="
+	GTE          = ">="
+	CONCAT       = "++"
+	PIPE         = "|>"
+	MATCH        = "~"
+	NOTMATCH     = "!~"
+	MATCH_ASSIGN = "~="
+	RANGE        = ".."
+	RANGE_INCL   = "..="
+	ELLIPSIS     = "..."
+	DOT          = "."
 
 	// delimiters
 	COMMA     = ","
@@ -67,6 +71,8 @@ const (
 	FOR      = "FOR"
 	IN       = "IN"
 	WHILE    = "WHILE"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 	AND      = "AND"
 	OR       = "OR"
 	NOT      = "NOT"
@@ -74,26 +80,35 @@ const (
 	MATCH_KW = "MATCH_KW"
 	TRY      = "TRY"
 	CATCH    = "CATCH"
+	IMPORT   = "IMPORT"
+	STRUCT   = "STRUCT"
+	YIELD    = "YIELD"
 	ARROW    = "=>"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FN,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"for":    FOR,
-	"in":     IN,
-	"while":  WHILE,
-	"and":    AND,
-	"or":     OR,
-	"not":    NOT,
-	"null":   NULL,
-	"try":    TRY,
-	"catch":  CATCH,
+	"fn":       FN,
+	"let":      LET,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"for":      FOR,
+	"in":       IN,
+	"while":    WHILE,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"and":      AND,
+	"or":       OR,
+	"not":      NOT,
+	"null":     NULL,
+	"match":    MATCH_KW,
+	"try":      TRY,
+	"catch":    CATCH,
+	"import":   IMPORT,
+	"struct":   STRUCT,
+	"yield":    YIELD,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -0,0 +1,25 @@
+package vm
+
+import (
+	"pearl/code"
+	"pearl/object"
+)
+
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// position is the source position of the instruction being executed
+func (f *Frame) position() (int, int) {
+	return f.cl.Fn.PositionAt(f.ip)
+}
@@ -0,0 +1,1030 @@
+package vm
+
+import (
+	"fmt"
+	"pearl/code"
+	"pearl/compiler"
+	"pearl/evaluator"
+	"pearl/object"
+	"strings"
+)
+
+const MaxFrames = 100000
+
+var (
+	NULL  = evaluator.NULL
+	TRUE  = evaluator.TRUE
+	FALSE = evaluator.FALSE
+)
+
+// cell holds a variable captured by a closure, so the closure and the
+// scope that defined the variable see each other's assignments
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+func (c *cell) Inspect() string         { return c.value.Inspect() }
+
+// iterator walks a for loop's iterable. next can return an *object.Error
+// as its value when reading fails.
+type iterator struct {
+	source object.Object
+	next   func() (object.Object, bool)
+	count  int64 // values taken so far
+	frame  int   // index of the frame running the loop
+
+	// values are the map values matching the keys next returns, for
+	// for k, v in m
+	values []object.Object
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
+
+// missing marks a parameter the caller left out and OpDefault must fill
+type missing struct{}
+
+func (m *missing) Type() object.ObjectType { return "MISSING" }
+func (m *missing) Inspect() string         { return "missing" }
+
+var missingArg = &missing{}
+
+// handler is an active try block
+type handler struct {
+	catchIP    int
+	sp         int
+	frameIndex int
+	loops      int // loops running when the try began
+}
+
+type VM struct {
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+	globalIndex map[string]int // for method lookup by name
+	file        string         // the script, which imports are found from
+
+	stack []object.Object
+	sp    int // always points to the next free slot, top of stack is stack[sp-1]
+
+	frames      []*Frame
+	framesIndex int
+
+	handlers []handler
+
+	// loops are the iterators of the for loops running, innermost last,
+	// so an error leaving them can stop them
+	loops []*iterator
+
+	// yield hands a value to the code iterating, when the vm is running
+	// a generator
+	yield func(object.Object)
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions, Positions: bytecode.Positions}
+	mainFrame := NewFrame(&object.Closure{Fn: mainFn}, 0)
+
+	vm := &VM{
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, bytecode.NumGlobals),
+		globalNames: bytecode.GlobalNames,
+		globalIndex: make(map[string]int),
+		stack:       make([]object.Object, 2048),
+		frames:      []*Frame{mainFrame},
+		framesIndex: 1,
+	}
+
+	for i, name := range bytecode.GlobalNames {
+		vm.globalIndex[name] = i
+	}
+	return vm
+}
+
+// SetGlobal assigns a global by name. Names the program never mentions
+// are ignored.
+func (vm *VM) SetGlobal(name string, val object.Object) {
+	if idx, ok := vm.globalIndex[name]; ok {
+		vm.globals[idx] = store(vm.globals[idx], val)
+	}
+}
+
+// SetFile records the path of the script, for imports to be resolved
+// against
+func (vm *VM) SetFile(path string) {
+	vm.file = path
+}
+
+// Global reads a global by name
+func (vm *VM) Global(name string) (object.Object, bool) {
+	idx, ok := vm.globalIndex[name]
+	if !ok || vm.globals[idx] == nil {
+		return nil, false
+	}
+	return deref(vm.globals[idx]), true
+}
+
+// Run executes the program, returning the value of a top level return,
+// an *object.Error that nothing caught, or null. Running again starts
+// the program over but keeps its globals.
+func (vm *VM) Run() object.Object {
+	vm.frames[0].ip = -1
+	vm.framesIndex = 1
+	vm.sp = 0
+	vm.handlers = vm.handlers[:0]
+	vm.loops = vm.loops[:0]
+	return vm.run(0)
+}
+
+// run executes until the frame at index base returns, or an error
+// escapes it
+func (vm *VM) run(base int) object.Object {
+	for {
+		frame := vm.frames[vm.framesIndex-1]
+		frame.ip++
+		ins := frame.Instructions()
+		if frame.ip >= len(ins) {
+			// only the main program can run off its end
+			return NULL
+		}
+
+		ip := frame.ip
+		op := code.Opcode(ins[ip])
+		var err *object.Error
+
+		switch op {
+		case code.OpConstant:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			vm.push(vm.constants[idx])
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpDup:
+			vm.push(vm.stack[vm.sp-1])
+
+		case code.OpNull:
+			vm.push(NULL)
+
+		case code.OpTrue:
+			vm.push(TRUE)
+
+		case code.OpFalse:
+			vm.push(FALSE)
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpConcat,
+			code.OpEqual, code.OpNotEqual, code.OpLess, code.OpGreater,
+			code.OpLessEqual, code.OpGreaterEqual, code.OpMatch, code.OpNotMatch:
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(executeBinaryOperation(op, left, right))
+
+		case code.OpMinus:
+			err = vm.pushResult(evaluator.PrefixOp("-", vm.pop()))
+
+		case code.OpBang:
+			err = vm.pushResult(evaluator.PrefixOp("!", vm.pop()))
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			if !evaluator.IsTruthy(vm.pop()) {
+				frame.ip = pos - 1
+			}
+
+		case code.OpGetGlobal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			val := vm.globals[idx]
+			if val == nil {
+				// unset globals fall back to builtins, like evalIdentifier
+				builtin, ok := evaluator.LookupBuiltin(vm.globalNames[idx])
+				if !ok {
+					err = undefinedVariable(vm.globalNames[idx])
+					break
+				}
+				val = builtin
+			}
+			vm.push(evaluator.Hold(deref(val)))
+
+		case code.OpSetGlobal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			val := vm.pop()
+			if vm.globals[idx] == nil {
+				err = undefinedVariable(vm.globalNames[idx])
+				break
+			}
+			vm.globals[idx] = store(vm.globals[idx], val)
+
+		case code.OpDefineGlobal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			isCell := ins[ip+3] == 1
+			frame.ip += 3
+			vm.globals[idx] = define(vm.pop(), isCell)
+
+		case code.OpGetLocal:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			val := vm.stack[frame.basePointer+idx]
+			if val == nil {
+				err = undefinedVariable(frame.cl.Fn.LocalNames[idx])
+				break
+			}
+			vm.push(evaluator.Hold(deref(val)))
+
+		case code.OpSetLocal:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			slot := frame.basePointer + idx
+			val := vm.pop()
+			if vm.stack[slot] == nil {
+				err = undefinedVariable(frame.cl.Fn.LocalNames[idx])
+				break
+			}
+			vm.stack[slot] = store(vm.stack[slot], val)
+
+		case code.OpDefineLocal:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			isCell := ins[ip+2] == 1
+			frame.ip += 2
+			vm.stack[frame.basePointer+idx] = define(vm.pop(), isCell)
+
+		case code.OpGetFree:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			vm.push(evaluator.Hold(deref(frame.cl.Free[idx])))
+
+		case code.OpSetFree:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			frame.cl.Free[idx] = store(frame.cl.Free[idx], vm.pop())
+
+		case code.OpCaptureGlobal:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			vm.push(vm.globals[idx])
+
+		case code.OpCaptureLocal:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			vm.push(vm.stack[frame.basePointer+idx])
+
+		case code.OpCaptureFree:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			vm.push(frame.cl.Free[idx])
+
+		case code.OpArray:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			elements := make([]object.Object, n)
+			copy(elements, vm.stack[vm.sp-n:vm.sp])
+			vm.sp -= n
+			vm.push(&object.Array{Elements: elements})
+
+		case code.OpMap:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			keys := make([]object.Object, n)
+			values := make([]object.Object, n)
+			for i := 0; i < n; i++ {
+				keys[i] = vm.stack[vm.sp-2*n+2*i]
+				values[i] = vm.stack[vm.sp-2*n+2*i+1]
+			}
+			vm.sp -= 2 * n
+			err = vm.pushResult(evaluator.MakeMap(keys, values))
+
+		case code.OpRange:
+			flags := code.ReadUint8(ins[ip+1:])
+			frame.ip++
+			var step object.Object
+			if flags&2 != 0 {
+				step = vm.pop()
+			}
+			end := vm.pop()
+			start := vm.pop()
+			err = vm.pushResult(evaluator.MakeRange(start, end, step, flags&1 != 0))
+
+		case code.OpInterpolate:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			var out strings.Builder
+			for _, part := range vm.stack[vm.sp-n : vm.sp] {
+				out.WriteString(part.Inspect())
+			}
+			vm.sp -= n
+			vm.push(&object.String{Value: out.String()})
+
+		case code.OpFormat:
+			spec := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			frame.ip += 2
+			err = vm.pushResult(evaluator.FormatValue(vm.pop(), spec.Value))
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.IndexOp(left, index))
+
+		case code.OpSetIndex:
+			index := vm.pop()
+			left := vm.pop()
+			val := vm.pop()
+			err = vm.pushResult(evaluator.SetIndex(left, index, val))
+
+		case code.OpGetMember:
+			name := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			frame.ip += 2
+			err = vm.pushResult(evaluator.GetMember(vm.pop(), name.Value))
+
+		case code.OpSetMember:
+			name := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			frame.ip += 2
+			obj := vm.pop()
+			val := vm.pop()
+			err = vm.pushResult(evaluator.SetMember(obj, name.Value, val))
+
+		case code.OpUnpackArray, code.OpUnpackMap:
+			operand := int(code.ReadUint16(ins[ip+1:]))
+			rest := code.ReadUint8(ins[ip+3:]) == 1
+			frame.ip += 3
+
+			var parts []object.Object
+			if op == code.OpUnpackArray {
+				parts, err = evaluator.DestructureArray(vm.pop(), operand, rest)
+			} else {
+				keys := make([]string, 0)
+				for _, k := range vm.constants[operand].(*object.Array).Elements {
+					keys = append(keys, k.(*object.String).Value)
+				}
+				parts, err = evaluator.DestructureMap(vm.pop(), keys, rest)
+			}
+			if err != nil {
+				break
+			}
+			// the rest is bound last, so it goes deepest
+			if rest {
+				vm.push(parts[len(parts)-1])
+				parts = parts[:len(parts)-1]
+			}
+			for i := len(parts) - 1; i >= 0; i-- {
+				vm.push(parts[i])
+			}
+
+		case code.OpClosure:
+			idx := int(code.ReadUint16(ins[ip+1:]))
+			numFree := int(code.ReadUint8(ins[ip+3:]))
+			frame.ip += 3
+			free := make([]object.Object, numFree)
+			copy(free, vm.stack[vm.sp-numFree:vm.sp])
+			vm.sp -= numFree
+			fn := vm.constants[idx].(*object.CompiledFunction)
+			// map, filter and the like call it back on this vm
+			vm.push(&object.Closure{Fn: fn, Free: free, Call: vm.callClosure})
+
+		case code.OpCall:
+			argc := int(code.ReadUint8(ins[ip+1:]))
+			frame.ip++
+			err = vm.callFunction(argc, nil)
+
+		case code.OpCallNamed:
+			argc := int(code.ReadUint8(ins[ip+1:]))
+			names := vm.constants[code.ReadUint16(ins[ip+2:])].(*object.Array)
+			frame.ip += 3
+			err = vm.callFunction(argc, names.Elements)
+
+		case code.OpCallMethod:
+			name := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			argc := int(code.ReadUint8(ins[ip+3:]))
+			names := vm.constants[code.ReadUint16(ins[ip+4:])].(*object.Array)
+			var local object.Object
+			if ins[ip+6] == 1 {
+				local = deref(vm.pop())
+			}
+			frame.ip += 6
+			err = vm.callMethod(name.Value, argc, names.Elements, local)
+
+		case code.OpReturnValue:
+			val := vm.pop()
+			idx := vm.framesIndex - 1
+			if idx == 0 {
+				// return at the top level ends the program
+				return val
+			}
+			vm.popFrame()
+			if idx == base {
+				return val
+			}
+			vm.push(val)
+
+		case code.OpYield:
+			val := vm.pop()
+			if vm.yield == nil {
+				err = &object.Error{Message: "yield outside a generator"}
+				break
+			}
+			vm.yield(val)
+
+		case code.OpStruct:
+			tmpl := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.StructType)
+			frame.ip += 2
+			vm.push(&object.StructType{
+				Name:    tmpl.Name,
+				Fields:  tmpl.Fields,
+				Init:    vm.pop(),
+				Methods: make(map[string]object.Object),
+			})
+
+		case code.OpDefineMethod:
+			name := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			frame.ip += 2
+			fn := vm.pop()
+			err = vm.pushResult(evaluator.DefineMethod(vm.pop(), name.Value, fn))
+
+		case code.OpDefault:
+			idx := int(code.ReadUint8(ins[ip+1:]))
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			frame.ip += 3
+			if vm.stack[frame.basePointer+idx] != missingArg {
+				frame.ip = pos - 1
+			}
+
+		case code.OpIter:
+			it, iterErr := newIterator(vm.pop())
+			if iterErr != nil {
+				err = iterErr
+				break
+			}
+			it.frame = vm.framesIndex - 1
+			vm.loops = append(vm.loops, it)
+			vm.push(it)
+
+		case code.OpIterNext:
+			withIndex := code.ReadUint8(ins[ip+1:]) == 1
+			pos := int(code.ReadUint16(ins[ip+2:]))
+			frame.ip += 3
+			it := vm.pop().(*iterator)
+			if val, ok := it.next(); ok {
+				if e, isErr := val.(*object.Error); isErr {
+					err = e
+					break
+				}
+				it.count++
+				if withIndex {
+					key := object.Object(&object.Integer{Value: it.count - 1})
+					if it.values != nil {
+						key, val = val, it.values[it.count-1]
+					}
+					vm.push(val)
+					vm.push(key)
+				} else {
+					vm.push(val)
+				}
+			} else {
+				vm.endLoop(it)
+				frame.ip = pos - 1
+			}
+
+		case code.OpIterClose:
+			it := vm.pop().(*iterator)
+			vm.endLoop(it)
+			evaluator.StopIterating(it.source)
+
+		case code.OpTry:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			vm.handlers = append(vm.handlers, handler{catchIP: pos, sp: vm.sp, frameIndex: vm.framesIndex - 1, loops: len(vm.loops)})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpRaise:
+			idx := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+			err = &object.Error{Message: vm.constants[idx].(*object.String).Value}
+
+		case code.OpMatchLen:
+			n := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+			arr, ok := vm.pop().(*object.Array)
+			vm.push(nativeBoolToBooleanObject(ok && len(arr.Elements) == n))
+
+		case code.OpInRange:
+			rng := vm.pop().(*object.Range)
+			vm.push(nativeBoolToBooleanObject(rng.Contains(vm.pop())))
+
+		case code.OpRegexGroups:
+			re := vm.pop().(*object.Regex)
+			vm.push(regexGroups(vm.pop(), re))
+
+		case code.OpMatchEqual:
+			right := vm.pop()
+			left := vm.pop()
+			vm.push(nativeBoolToBooleanObject(evaluator.Equal(left, right)))
+
+		case code.OpImport:
+			path := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			frame.ip += 2
+			err = vm.pushResult(evaluator.Import(path.Value, vm.file))
+
+		default:
+			def, _ := code.Lookup(byte(op))
+			err = &object.Error{Message: fmt.Sprintf("vm: unhandled opcode %v", def)}
+		}
+
+		if err != nil {
+			if escaped := vm.handleError(err, base); escaped != nil {
+				return escaped
+			}
+		}
+	}
+}
+
+// pushResult pushes the result of a shared evaluator operation, or
+// returns it if it is an error
+func (vm *VM) pushResult(result object.Object) *object.Error {
+	if errObj, ok := result.(*object.Error); ok {
+		return errObj
+	}
+	vm.push(result)
+	return nil
+}
+
+// handleError unwinds to the innermost try block at or above base and
+// jumps to its catch. If there is none the error escapes run and is
+// returned.
+func (vm *VM) handleError(err *object.Error, base int) *object.Error {
+	if err.Line == 0 {
+		err.Line, err.Col = vm.frames[vm.framesIndex-1].position()
+	}
+
+	target := base
+	var h *handler
+	if n := len(vm.handlers); n > 0 && vm.handlers[n-1].frameIndex >= base && !err.IsExit() {
+		h = &vm.handlers[n-1]
+		target = h.frameIndex
+	}
+
+	// record each Pearl function the error leaves, the base frame of a
+	// callback is skipped as its caller is a builtin
+	for idx := vm.framesIndex - 1; idx > target; idx-- {
+		vm.addStackFrame(err, idx)
+	}
+
+	if h == nil {
+		n := len(vm.loops)
+		for n > 0 && vm.loops[n-1].frame >= base {
+			n--
+		}
+		vm.stopLoops(n)
+		if base > 0 {
+			vm.sp = vm.frames[base].basePointer - 1
+			vm.framesIndex = base
+		}
+		vm.dropHandlers(base)
+		return err
+	}
+
+	catch := *h
+	vm.stopLoops(catch.loops)
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+	vm.framesIndex = catch.frameIndex + 1
+	vm.sp = catch.sp
+	vm.push(evaluator.ErrorValue(err))
+	vm.frames[catch.frameIndex].ip = catch.catchIP - 1
+	return nil
+}
+
+func (vm *VM) addStackFrame(err *object.Error, idx int) {
+	name := vm.frames[idx].cl.Fn.Name
+	if name == "" {
+		name = "<anonymous fn>"
+	}
+	line, col := vm.frames[idx-1].position()
+	err.Stack = append(err.Stack, object.Frame{Function: name, Line: line, Col: col})
+}
+
+// endLoop forgets the iterator of a loop that has finished
+func (vm *VM) endLoop(it *iterator) {
+	for i := len(vm.loops) - 1; i >= 0; i-- {
+		if vm.loops[i] == it {
+			vm.loops = append(vm.loops[:i], vm.loops[i+1:]...)
+			return
+		}
+	}
+}
+
+// stopLoops stops the iterators of the loops an error leaves, all but the
+// first n
+func (vm *VM) stopLoops(n int) {
+	for i := len(vm.loops) - 1; i >= n; i-- {
+		evaluator.StopIterating(vm.loops[i].source)
+	}
+	vm.loops = vm.loops[:n]
+}
+
+// dropHandlers discards try blocks opened by frames at or above idx
+func (vm *VM) dropHandlers(idx int) {
+	n := len(vm.handlers)
+	for n > 0 && vm.handlers[n-1].frameIndex >= idx {
+		n--
+	}
+	vm.handlers = vm.handlers[:n]
+}
+
+func (vm *VM) callFunction(argc int, names []object.Object) *object.Error {
+	callee := vm.stack[vm.sp-1-argc]
+
+	switch callee := callee.(type) {
+	case *object.Closure:
+		if callee.Fn.Generator {
+			args := make([]object.Object, argc)
+			copy(args, vm.stack[vm.sp-argc:vm.sp])
+			vm.sp = vm.sp - argc - 1
+			gen, err := vm.startGenerator(callee, args, names)
+			if err != nil {
+				return err
+			}
+			vm.push(gen)
+			return nil
+		}
+		return vm.pushClosureFrame(callee, argc, names)
+
+	case *object.StructType:
+		if _, ok := callee.Init.(*object.Closure); !ok {
+			return vm.callEvaluator(callee, argc, names)
+		}
+		// run the constructor to completion, then wrap the field values
+		vm.stack[vm.sp-1-argc] = callee.Init
+		if err := vm.pushClosureFrame(callee.Init.(*object.Closure), argc, names); err != nil {
+			return err
+		}
+		return vm.pushResult(evaluator.NewStruct(callee, vm.run(vm.framesIndex-1)))
+
+	case *object.Builtin:
+		args := make([]object.Object, argc)
+		copy(args, vm.stack[vm.sp-argc:vm.sp])
+		vm.sp = vm.sp - argc - 1
+
+		if names != nil {
+			var argNames []string
+			var err *object.Error
+			if args, argNames, err = evaluator.SpreadArgs(args, stringsOf(names)); err != nil {
+				return err
+			}
+			if args, err = evaluator.BindBuiltinArgs(callee, args, argNames); err != nil {
+				return err
+			}
+		}
+
+		result := callee.Fn(args...)
+		if result == nil {
+			result = NULL
+		}
+		return vm.pushResult(result)
+
+	case *object.Function:
+		return vm.callEvaluator(callee, argc, names)
+
+	default:
+		return &object.Error{Kind: object.TYPE_ERROR, Message: fmt.Sprintf("not a function: %s", callee.Type())}
+	}
+}
+
+// callMethod calls recv.name(args) with the receiver and arguments on the
+// stack, dispatching like evalMethodCall: fields of recv are called
+// directly, anything else gets recv as its first argument. local is the
+// value of a local variable called name, or nil.
+func (vm *VM) callMethod(name string, argc int, names []object.Object, local object.Object) *object.Error {
+	recvSlot := vm.sp - 1 - argc
+	recv := vm.stack[recvSlot]
+
+	if fn, ok := evaluator.MethodCallee(recv, name); ok {
+		if err, isErr := fn.(*object.Error); isErr {
+			return err
+		}
+		vm.stack[recvSlot] = fn
+		return vm.callFunction(argc, names)
+	}
+
+	fn, ok := vm.lookupMethod(recv, name, local)
+	if !ok {
+		return &object.Error{Kind: object.TYPE_ERROR, Message: fmt.Sprintf("%s has no method %s", evaluator.TypeName(recv), name)}
+	}
+
+	// slide the receiver and arguments up to make room for the function
+	vm.ensureStack(vm.sp + 1)
+	copy(vm.stack[recvSlot+1:vm.sp+1], vm.stack[recvSlot:vm.sp])
+	vm.stack[recvSlot] = fn
+	vm.sp++
+
+	return vm.callFunction(argc+1, append([]object.Object{&object.String{}}, names...))
+}
+
+// lookupMethod finds a method by name among recv's struct methods, the
+// local variable the compiler found, the globals, then the builtins
+func (vm *VM) lookupMethod(recv object.Object, name string, local object.Object) (object.Object, bool) {
+	if fn, ok := evaluator.StructMethod(recv, name); ok {
+		return fn, true
+	}
+	if local != nil {
+		return local, true
+	}
+	if idx, ok := vm.globalIndex[name]; ok && vm.globals[idx] != nil {
+		return deref(vm.globals[idx]), true
+	}
+	if builtin, ok := evaluator.LookupBuiltin(name); ok {
+		return builtin, true
+	}
+	return nil, false
+}
+
+// pushClosureFrame binds the arguments on the stack to cl's parameters
+// the way extendFunctionEnv does and enters the function
+func (vm *VM) pushClosureFrame(cl *object.Closure, argc int, names []object.Object) *object.Error {
+	if vm.framesIndex >= MaxFrames {
+		return &object.Error{Message: "stack overflow"}
+	}
+
+	fn := cl.Fn
+	args := vm.stack[vm.sp-argc : vm.sp]
+
+	var argNames []string
+	if names != nil {
+		var err *object.Error
+		if args, argNames, err = evaluator.SpreadArgs(args, stringsOf(names)); err != nil {
+			return err
+		}
+	}
+	bound, err := evaluator.BindArgs(fn.Name, fn.Params, fn.Variadic, args, argNames)
+	if err != nil {
+		return err
+	}
+	for i, val := range bound {
+		if val != nil {
+			continue
+		}
+		if fn.HasDefault[i] {
+			bound[i] = missingArg
+		} else {
+			bound[i] = NULL
+		}
+	}
+
+	basePointer := vm.sp - argc
+	vm.ensureStack(basePointer + fn.NumLocals)
+	copy(vm.stack[basePointer:], bound)
+	for i := basePointer + len(bound); i < basePointer+fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = basePointer + fn.NumLocals
+
+	frame := NewFrame(cl, basePointer)
+	if vm.framesIndex < len(vm.frames) {
+		vm.frames[vm.framesIndex] = frame
+	} else {
+		vm.frames = append(vm.frames, frame)
+	}
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() {
+	frame := vm.frames[vm.framesIndex-1]
+	vm.dropHandlers(vm.framesIndex - 1)
+	vm.framesIndex--
+	vm.sp = frame.basePointer - 1
+}
+
+// startGenerator calls a function that yields. The iterator it returns
+// runs the function on a vm of its own, which shares the program's globals
+// but has its own stack to pause with.
+func (vm *VM) startGenerator(cl *object.Closure, args []object.Object, names []object.Object) (object.Object, *object.Error) {
+	gen := &VM{
+		constants:   vm.constants,
+		globals:     vm.globals,
+		globalNames: vm.globalNames,
+		globalIndex: vm.globalIndex,
+		file:        vm.file,
+		stack:       make([]object.Object, 256),
+		frames:      []*Frame{NewFrame(&object.Closure{Fn: &object.CompiledFunction{}}, 0)},
+		framesIndex: 1,
+	}
+	gen.push(cl)
+	for _, arg := range args {
+		gen.push(arg)
+	}
+	if err := gen.pushClosureFrame(cl, len(args), names); err != nil {
+		return nil, err
+	}
+
+	return evaluator.NewGenerator(cl.Fn.Name, func(yield func(object.Object)) object.Object {
+		gen.yield = yield
+		return gen.run(1)
+	}), nil
+}
+
+// callEvaluator calls a function or struct type an imported module made,
+// which runs on the evaluator
+func (vm *VM) callEvaluator(callee object.Object, argc int, names []object.Object) *object.Error {
+	args := make([]object.Object, argc)
+	copy(args, vm.stack[vm.sp-argc:vm.sp])
+	vm.sp = vm.sp - argc - 1
+
+	var argNames []string
+	if names != nil {
+		var err *object.Error
+		if args, argNames, err = evaluator.SpreadArgs(args, stringsOf(names)); err != nil {
+			return err
+		}
+	}
+	result := evaluator.Apply(callee, args, argNames)
+
+	// record the function the error leaves, as addStackFrame does
+	if errObj, ok := result.(*object.Error); ok {
+		if fn, ok := callee.(*object.Function); ok {
+			name := fn.Name
+			if name == "" {
+				name = "<anonymous fn>"
+			}
+			line, col := vm.frames[vm.framesIndex-1].position()
+			if errObj.Line == 0 {
+				errObj.Line, errObj.Col = line, col
+			}
+			errObj.Stack = append(errObj.Stack, object.Frame{Function: name, Line: line, Col: col})
+		}
+	}
+	return vm.pushResult(result)
+}
+
+// callClosure runs cl to completion on behalf of a builtin
+func (vm *VM) callClosure(cl *object.Closure, args []object.Object) object.Object {
+	if cl.Fn.Generator {
+		gen, err := vm.startGenerator(cl, args, nil)
+		if err != nil {
+			return err
+		}
+		return gen
+	}
+	vm.push(cl)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	if err := vm.pushClosureFrame(cl, len(args), nil); err != nil {
+		vm.sp -= len(args) + 1
+		return err
+	}
+	return vm.run(vm.framesIndex - 1)
+}
+
+// stringsOf unpacks the argument names constant of a call
+func stringsOf(names []object.Object) []string {
+	out := make([]string, len(names))
+	for i, n := range names {
+		out[i] = n.(*object.String).Value
+	}
+	return out
+}
+
+func (vm *VM) ensureStack(size int) {
+	for size >= len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+}
+
+func (vm *VM) push(o object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.ensureStack(vm.sp)
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpConcat:       "++",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpLess:         "<",
+	code.OpGreater:      ">",
+	code.OpLessEqual:    "<=",
+	code.OpGreaterEqual: ">=",
+	code.OpMatch:        "~",
+	code.OpNotMatch:     "!~",
+}
+
+func executeBinaryOperation(op code.Opcode, left, right object.Object) object.Object {
+	// fast path for the integer arithmetic loops spend their time in
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		switch op {
+		case code.OpAdd:
+			return &object.Integer{Value: l.Value + r.Value}
+		case code.OpSub:
+			return &object.Integer{Value: l.Value - r.Value}
+		case code.OpMul:
+			return &object.Integer{Value: l.Value * r.Value}
+		case code.OpLess:
+			return nativeBoolToBooleanObject(l.Value < r.Value)
+		case code.OpGreater:
+			return nativeBoolToBooleanObject(l.Value > r.Value)
+		case code.OpEqual:
+			return nativeBoolToBooleanObject(l.Value == r.Value)
+		}
+	}
+	return evaluator.InfixOp(binaryOperators[op], left, right)
+}
+
+func newIterator(obj object.Object) (*iterator, *object.Error) {
+	// maps keep their values so for k, v in m can bind both
+	if m, ok := evaluator.Iterable(obj).(*object.Map); ok {
+		keys := make([]object.Object, 0, m.Len())
+		values := make([]object.Object, 0, m.Len())
+		for _, pair := range m.Ordered() {
+			keys = append(keys, pair.Key)
+			values = append(values, pair.Value)
+		}
+		i := 0
+		return &iterator{source: obj, values: values, next: func() (object.Object, bool) {
+			if i >= len(keys) {
+				return nil, false
+			}
+			i++
+			return keys[i-1], true
+		}}, nil
+	}
+
+	next, err := evaluator.Iterate(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &iterator{source: obj, next: next}, nil
+}
+
+// regexGroups returns the whole match and captures, or null
+func regexGroups(subject object.Object, re *object.Regex) object.Object {
+	str, ok := subject.(*object.String)
+	if !ok {
+		return NULL
+	}
+	groups := re.Regexp.FindStringSubmatch(str.Value)
+	if groups == nil {
+		return NULL
+	}
+	elements := make([]object.Object, len(groups))
+	for i, g := range groups {
+		elements[i] = &object.String{Value: g}
+	}
+	return &object.Array{Elements: elements}
+}
+
+func deref(obj object.Object) object.Object {
+	if c, ok := obj.(*cell); ok {
+		return c.value
+	}
+	return obj
+}
+
+// store assigns val to a slot, writing through a cell if there is one
+func store(slot, val object.Object) object.Object {
+	if c, ok := slot.(*cell); ok {
+		c.value = val
+		return c
+	}
+	return val
+}
+
+func define(val object.Object, isCell bool) object.Object {
+	if isCell {
+		return &cell{value: val}
+	}
+	return val
+}
+
+func undefinedVariable(name string) *object.Error {
+	return &object.Error{Kind: object.NAME_ERROR, Message: fmt.Sprintf("undefined variable: %s", name)}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}